@@ -0,0 +1,143 @@
+// Package auth authenticates api callers by bearer tokens: JWTs signed with a local key
+// for users and shared secret tokens for other services
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+)
+
+// UserHeader - header with id of the user a service makes request for
+const UserHeader = "X-User-ID"
+
+var (
+	// ErrUnknownToken - token is not handled by authenticator, the next one should be tried
+	ErrUnknownToken = errors.New("unknown token")
+	// ErrInvalidToken - token is handled by authenticator but is not valid
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Principal - authenticated caller. Service is name of the calling service for service tokens,
+// UserID is then the user service acts for and may be empty
+type Principal struct {
+	UserID  string
+	Service string
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request, token string) (Principal, error)
+}
+
+// Chain - tries authenticators in order until one of them knows the token
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request, token string) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r, token)
+		if err != ErrUnknownToken {
+			return p, err
+		}
+	}
+	return Principal{}, ErrUnknownToken
+}
+
+// New - authenticator from config, service tokens are checked before jwt
+func New(cfg config.Auth) (Authenticator, error) {
+	var chain Chain
+	if len(cfg.ServiceTokens) != 0 {
+		chain = append(chain, ServiceTokens(cfg.ServiceTokens))
+	}
+	if cfg.JWTSecret != "" || cfg.JWTPublicKeyFile != "" {
+		j, err := NewJWT(cfg)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, j)
+	}
+	return chain, nil
+}
+
+// ServiceTokens - shared secret tokens by service name
+type ServiceTokens map[string]string
+
+func (s ServiceTokens) Authenticate(r *http.Request, token string) (Principal, error) {
+	for name, secret := range s {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1 {
+			return Principal{UserID: r.Header.Get(UserHeader), Service: name}, nil
+		}
+	}
+	return Principal{}, ErrUnknownToken
+}
+
+// JWT - verifies tokens signed with hmac secret or with private key of configured public key,
+// user id is the sub claim
+type JWT struct {
+	key     interface{}
+	options []jwt.ParserOption
+}
+
+func NewJWT(cfg config.Auth) (*JWT, error) {
+	j := &JWT{options: []jwt.ParserOption{jwt.WithExpirationRequired()}}
+	if cfg.JWTIssuer != "" {
+		j.options = append(j.options, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+	if cfg.JWTAudience != "" {
+		j.options = append(j.options, jwt.WithAudience(cfg.JWTAudience))
+	}
+
+	if cfg.JWTPublicKeyFile == "" {
+		j.key = []byte(cfg.JWTSecret)
+		j.options = append(j.options, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+		return j, nil
+	}
+
+	pem, err := os.ReadFile(cfg.JWTPublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading jwt public key: %w", err)
+	}
+	if j.key, err = jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		j.options = append(j.options, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}))
+		return j, nil
+	}
+	if j.key, err = jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+		j.options = append(j.options, jwt.WithValidMethods([]string{"ES256", "ES384", "ES512"}))
+		return j, nil
+	}
+	if j.key, err = jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+		j.options = append(j.options, jwt.WithValidMethods([]string{"EdDSA"}))
+		return j, nil
+	}
+	return nil, errors.New("jwt public key is not rsa, ecdsa or ed25519 pem")
+}
+
+func (j *JWT) Authenticate(_ *http.Request, token string) (Principal, error) {
+	t, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return j.key, nil }, j.options...)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	sub, err := t.Claims.GetSubject()
+	if err != nil || sub == "" {
+		return Principal{}, fmt.Errorf("%w: sub claim is required", ErrInvalidToken)
+	}
+	return Principal{UserID: sub}, nil
+}
+
+type ctxKey struct{}
+
+// NewContext - returns ctx carrying p
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext - principal of authenticated request, false when auth is disabled
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,486 @@
+// Package config loads service settings from defaults, yaml file, environment variables
+// and command line flags, each source overrides the previous one
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix - prefix of environment variables, e.g. SPOTIFY_DB_MONGO_URI
+const EnvPrefix = "SPOTIFY_DB_"
+
+// MinServiceTokenLength - shortest accepted service token
+const MinServiceTokenLength = 32
+
+type Config struct {
+	// Listen - address http server listens on
+	Listen   string   `yaml:"listen"`
+	InMemory bool     `yaml:"in_memory"`
+	Mongo    Mongo    `yaml:"mongo"`
+	Timeouts Timeouts `yaml:"timeouts"`
+	// ShutdownTimeout - how long in-flight requests are drained on SIGINT/SIGTERM before exit
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	Auth            Auth          `yaml:"auth"`
+	Log             Log           `yaml:"log"`
+	Tracing         Tracing       `yaml:"tracing"`
+	Upload          Upload        `yaml:"upload"`
+}
+
+// Upload - limits of streamed multipart uploads, segments are also limited by validation.MaxSegmentSize,
+// and lifetime of resumable upload sessions
+type Upload struct {
+	// MaxSize - max size of upload request body in bytes
+	MaxSize int `yaml:"max_size"`
+	// SessionTTL - upload session expires when no segment was uploaded to it for this long
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// CleanupInterval - how often segments of expired sessions are removed
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// Tracing - export of spans of routes, service methods and db calls
+type Tracing struct {
+	// Exporter - none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// OTLPEndpoint - host:port of otlp http receiver, empty means OTEL_EXPORTER_OTLP_* environment or localhost:4318
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	OTLPInsecure bool   `yaml:"otlp_insecure"`
+}
+
+// TracingExporters - accepted values of Tracing.Exporter
+var TracingExporters = []string{"none", "stdout", "otlp"}
+
+// Log - logger settings, production logs json and development logs readable console lines
+type Log struct {
+	Development bool `yaml:"development"`
+	// Level - debug, info, warn or error
+	Level string `yaml:"level"`
+}
+
+// LogLevels - accepted values of Log.Level
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Auth - api authentication, at least one of jwt key or service tokens is required unless
+// auth is disabled, so default config does not validate until one of them is set, e.g. local
+// runs pass -auth-disabled. Without auth user ids from request bodies are trusted
+type Auth struct {
+	Disabled bool `yaml:"disabled"`
+	// JWTSecret - hmac key of user tokens, JWTPublicKeyFile - pem public key of user tokens, only one is set
+	JWTSecret        string `yaml:"jwt_secret"`
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+	JWTIssuer        string `yaml:"jwt_issuer"`
+	JWTAudience      string `yaml:"jwt_audience"`
+	// ServiceTokens - shared secret tokens of other services by service name
+	ServiceTokens map[string]string `yaml:"service_tokens"`
+	// Admins - ids of users who are admins regardless of their stored roles
+	Admins []string `yaml:"admins"`
+}
+
+// Timeouts - deadlines of http requests, zero means no deadline
+type Timeouts struct {
+	// Request - deadline of every request without its own endpoint timeout
+	Request time.Duration `yaml:"request"`
+	// Endpoints - deadlines by route path, e.g. /api/v1/addSegment
+	Endpoints map[string]time.Duration `yaml:"endpoints"`
+}
+
+// For - returns timeout of route path
+func (t Timeouts) For(path string) time.Duration {
+	if d, ok := t.Endpoints[path]; ok {
+		return d
+	}
+	return t.Request
+}
+
+type Mongo struct {
+	URI        string `yaml:"uri"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	AuthSource string `yaml:"auth_source"`
+	TLS        TLS    `yaml:"tls"`
+
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	SocketTimeout  time.Duration `yaml:"socket_timeout"`
+	PoolSize       int           `yaml:"pool_size"`
+
+	Database    string      `yaml:"database"`
+	Collections Collections `yaml:"collections"`
+
+	// SegmentsBatchSize - max number of segments in one bulk insert
+	SegmentsBatchSize int `yaml:"segments_batch_size"`
+}
+
+type TLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type Collections struct {
+	Segments       string `yaml:"segments"`
+	Songs          string `yaml:"songs"`
+	Users          string `yaml:"users"`
+	Playlists      string `yaml:"playlists"`
+	UploadSessions string `yaml:"upload_sessions"`
+}
+
+// Default - settings used when nothing else is set, same as the service had before config existed
+func Default() Config {
+	return Config{
+		Listen:          ":8082",
+		ShutdownTimeout: 30 * time.Second,
+		Log:             Log{Level: "info"},
+		Tracing:         Tracing{Exporter: "none"},
+		Upload: Upload{
+			MaxSize:         1 << 30,
+			SessionTTL:      24 * time.Hour,
+			CleanupInterval: 10 * time.Minute,
+		},
+		Mongo: Mongo{
+			URI:            "mongodb://localhost:27017",
+			ConnectTimeout: 10 * time.Second,
+			SocketTimeout:  time.Minute,
+			PoolSize:       4096,
+			Database:       "spotify",
+			Collections: Collections{
+				Segments:       "segments",
+				Songs:          "songs",
+				Users:          "users",
+				Playlists:      "playlists",
+				UploadSessions: "upload_sessions",
+			},
+			SegmentsBatchSize: 100,
+		},
+		Timeouts: Timeouts{
+			Request: 30 * time.Second,
+			Endpoints: map[string]time.Duration{
+				// uploads carry whole song
+				"/api/v1/addSegment": 5 * time.Minute,
+				"/api/v1/upload":     15 * time.Minute,
+			},
+		},
+	}
+}
+
+// setting - one configurable value with its env variable and flag names
+type setting struct {
+	name   string
+	usage  string
+	isBool bool
+	set    func(c *Config, v string) error
+}
+
+func settings() []setting {
+	return []setting{
+		stringSetting("listen", "address to listen on", func(c *Config) *string { return &c.Listen }),
+		boolSetting("inmemory", "use in-memory storage instead of mongodb", func(c *Config) *bool { return &c.InMemory }),
+		stringSetting("mongo-uri", "mongodb connection uri", func(c *Config) *string { return &c.Mongo.URI }),
+		stringSetting("mongo-username", "mongodb username", func(c *Config) *string { return &c.Mongo.Username }),
+		stringSetting("mongo-password", "mongodb password", func(c *Config) *string { return &c.Mongo.Password }),
+		stringSetting("mongo-auth-source", "mongodb authentication database", func(c *Config) *string { return &c.Mongo.AuthSource }),
+		boolSetting("mongo-tls", "connect to mongodb over tls", func(c *Config) *bool { return &c.Mongo.TLS.Enabled }),
+		stringSetting("mongo-tls-ca-file", "pem file with mongodb ca certificates", func(c *Config) *string { return &c.Mongo.TLS.CAFile }),
+		boolSetting("mongo-tls-insecure", "skip mongodb certificate verification", func(c *Config) *bool { return &c.Mongo.TLS.InsecureSkipVerify }),
+		durationSetting("mongo-connect-timeout", "mongodb connect timeout", func(c *Config) *time.Duration { return &c.Mongo.ConnectTimeout }),
+		durationSetting("mongo-socket-timeout", "mongodb socket timeout", func(c *Config) *time.Duration { return &c.Mongo.SocketTimeout }),
+		intSetting("mongo-pool-size", "max number of mongodb connections per server", func(c *Config) *int { return &c.Mongo.PoolSize }),
+		stringSetting("mongo-database", "mongodb database name", func(c *Config) *string { return &c.Mongo.Database }),
+		stringSetting("mongo-segments-collection", "segments collection name", func(c *Config) *string { return &c.Mongo.Collections.Segments }),
+		stringSetting("mongo-songs-collection", "songs collection name", func(c *Config) *string { return &c.Mongo.Collections.Songs }),
+		stringSetting("mongo-users-collection", "users collection name", func(c *Config) *string { return &c.Mongo.Collections.Users }),
+		stringSetting("mongo-playlists-collection", "playlists collection name", func(c *Config) *string { return &c.Mongo.Collections.Playlists }),
+		stringSetting("mongo-upload-sessions-collection", "upload sessions collection name", func(c *Config) *string { return &c.Mongo.Collections.UploadSessions }),
+		intSetting("segments-batch", "max number of segments in one bulk insert", func(c *Config) *int { return &c.Mongo.SegmentsBatchSize }),
+		intSetting("upload-max-size", "max size of streamed upload in bytes", func(c *Config) *int { return &c.Upload.MaxSize }),
+		durationSetting("upload-session-ttl", "how long upload session lives without uploads", func(c *Config) *time.Duration { return &c.Upload.SessionTTL }),
+		durationSetting("upload-cleanup-interval", "how often expired upload sessions are removed", func(c *Config) *time.Duration { return &c.Upload.CleanupInterval }),
+		durationSetting("shutdown-timeout", "how long in-flight requests are drained on shutdown", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+		boolSetting("log-development", "log readable console lines instead of json", func(c *Config) *bool { return &c.Log.Development }),
+		stringSetting("log-level", "min level of logged messages: "+strings.Join(LogLevels, ", "), func(c *Config) *string { return &c.Log.Level }),
+		stringSetting("tracing-exporter", "where spans are exported: "+strings.Join(TracingExporters, ", "), func(c *Config) *string { return &c.Tracing.Exporter }),
+		stringSetting("tracing-otlp-endpoint", "host:port of otlp http receiver", func(c *Config) *string { return &c.Tracing.OTLPEndpoint }),
+		boolSetting("tracing-otlp-insecure", "export spans to otlp receiver over plain http", func(c *Config) *bool { return &c.Tracing.OTLPInsecure }),
+		boolSetting("auth-disabled", "serve api without authentication, auth is on by default and needs jwt key or service tokens", func(c *Config) *bool { return &c.Auth.Disabled }),
+		stringSetting("auth-jwt-secret", "hmac key of user jwts", func(c *Config) *string { return &c.Auth.JWTSecret }),
+		stringSetting("auth-jwt-public-key-file", "pem public key of user jwts", func(c *Config) *string { return &c.Auth.JWTPublicKeyFile }),
+		stringSetting("auth-jwt-issuer", "required iss claim of user jwts", func(c *Config) *string { return &c.Auth.JWTIssuer }),
+		stringSetting("auth-jwt-audience", "required aud claim of user jwts", func(c *Config) *string { return &c.Auth.JWTAudience }),
+		mapSetting("auth-service-tokens", "service tokens as name=token list separated by commas, added to the ones from config file",
+			func(c *Config, k, v string) error {
+				if c.Auth.ServiceTokens == nil {
+					c.Auth.ServiceTokens = map[string]string{}
+				}
+				c.Auth.ServiceTokens[k] = v
+				return nil
+			}),
+		listSetting("auth-admins", "ids of admin users separated by commas", func(c *Config) *[]string { return &c.Auth.Admins }),
+		durationSetting("request-timeout", "deadline of http request, 0 disables it", func(c *Config) *time.Duration { return &c.Timeouts.Request }),
+		mapSetting("endpoint-timeouts", "deadlines of routes as path=duration list separated by commas, added to the ones from config file",
+			func(c *Config, k, v string) error {
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					return err
+				}
+				if c.Timeouts.Endpoints == nil {
+					c.Timeouts.Endpoints = map[string]time.Duration{}
+				}
+				c.Timeouts.Endpoints[k] = d
+				return nil
+			}),
+	}
+}
+
+// flagValue - raw value of setting flag, it is applied to config only if the flag was set
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// EnvName - environment variable of setting, e.g. mongo-uri is SPOTIFY_DB_MONGO_URI
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load - builds config from defaults, file from -config flag or SPOTIFY_DB_CONFIG,
+// environment variables and flags from args, then validates it
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("spotify-db", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(EnvName("config")), "path to yaml config file")
+
+	flags := map[string]*flagValue{}
+	all := settings()
+	for _, s := range all {
+		flags[s.name] = &flagValue{isBool: s.isBool}
+		fs.Var(flags[s.name], s.name, s.usage+", env "+EnvName(s.name))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("error parsing config file %s: %w", *path, err)
+		}
+	}
+
+	for _, s := range all {
+		v, ok := os.LookupEnv(EnvName(s.name))
+		if !ok {
+			continue
+		}
+		if err := s.set(&cfg, v); err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", EnvName(s.name), err)
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range all {
+			if s.name == f.Name && err == nil {
+				if serr := s.set(&cfg, flags[s.name].value); serr != nil {
+					err = fmt.Errorf("invalid -%s: %w", s.name, serr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate - checks that config can be used to start the service
+func (c Config) Validate() error {
+	var errs []string
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Sprintf("listen: %s", err))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, "shutdown timeout must be positive")
+	}
+	if c.Timeouts.Request < 0 {
+		errs = append(errs, "request timeout must not be negative")
+	}
+	for path, d := range c.Timeouts.Endpoints {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Sprintf("endpoint timeout path %q must start with /", path))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("endpoint timeout of %s must not be negative", path))
+		}
+	}
+
+	if c.Upload.MaxSize <= 0 {
+		errs = append(errs, "upload max size must be positive")
+	}
+	if c.Upload.SessionTTL <= 0 || c.Upload.CleanupInterval <= 0 {
+		errs = append(errs, "upload session ttl and cleanup interval must be positive")
+	}
+	if !contains(LogLevels, c.Log.Level) {
+		errs = append(errs, fmt.Sprintf("log level must be one of %s", strings.Join(LogLevels, ", ")))
+	}
+
+	if !contains(TracingExporters, c.Tracing.Exporter) {
+		errs = append(errs, fmt.Sprintf("tracing exporter must be one of %s", strings.Join(TracingExporters, ", ")))
+	}
+
+	if a := c.Auth; !a.Disabled {
+		if a.JWTSecret == "" && a.JWTPublicKeyFile == "" && len(a.ServiceTokens) == 0 {
+			errs = append(errs, "auth is on by default and needs jwt secret, jwt public key file or service tokens, or must be disabled with -auth-disabled")
+		}
+		if a.JWTSecret != "" && a.JWTPublicKeyFile != "" {
+			errs = append(errs, "only one of auth jwt secret and jwt public key file can be set")
+		}
+		if a.JWTPublicKeyFile != "" {
+			if _, err := os.Stat(a.JWTPublicKeyFile); err != nil {
+				errs = append(errs, fmt.Sprintf("auth jwt public key file: %s", err))
+			}
+		}
+		for name, token := range a.ServiceTokens {
+			if len(token) < MinServiceTokenLength {
+				errs = append(errs, fmt.Sprintf("auth service token of %s must be at least %d characters", name, MinServiceTokenLength))
+			}
+		}
+	}
+
+	if !c.InMemory {
+		m := c.Mongo
+		if m.URI == "" {
+			errs = append(errs, "mongo uri must not be empty")
+		} else if !strings.HasPrefix(m.URI, "mongodb://") && !strings.HasPrefix(m.URI, "mongodb+srv://") {
+			errs = append(errs, "mongo uri must start with mongodb:// or mongodb+srv://")
+		}
+		if m.Password != "" && m.Username == "" {
+			errs = append(errs, "mongo password is set without username")
+		}
+		if m.TLS.CAFile != "" {
+			if _, err := os.Stat(m.TLS.CAFile); err != nil {
+				errs = append(errs, fmt.Sprintf("mongo tls ca file: %s", err))
+			}
+		}
+		if m.ConnectTimeout <= 0 {
+			errs = append(errs, "mongo connect timeout must be positive")
+		}
+		if m.SocketTimeout < 0 {
+			errs = append(errs, "mongo socket timeout must not be negative")
+		}
+		if m.PoolSize < 0 {
+			errs = append(errs, "mongo pool size must not be negative")
+		}
+		if m.Database == "" {
+			errs = append(errs, "mongo database must not be empty")
+		}
+		if m.Collections.Segments == "" || m.Collections.Songs == "" ||
+			m.Collections.Users == "" || m.Collections.Playlists == "" || m.Collections.UploadSessions == "" {
+			errs = append(errs, "mongo collection names must not be empty")
+		}
+		if m.SegmentsBatchSize <= 0 {
+			errs = append(errs, "segments batch size must be positive")
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{name: name, usage: usage, isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func listSetting(name, usage string, field func(c *Config) *[]string) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}}
+}
+
+// mapSetting - setting of key=value list separated by commas, set is called for every pair
+func mapSetting(name, usage string, set func(c *Config, k, v string) error) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		for _, kv := range strings.Split(v, ",") {
+			if strings.TrimSpace(kv) == "" {
+				continue
+			}
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%q is not key=value", kv)
+			}
+			if err := set(c, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		// env - environment variables, value of SPOTIFY_DB_CONFIG is written to file and replaced by its path
+		env  map[string]string
+		args []string
+		// check - config loaded without error, err - substring of error otherwise
+		check func(t *testing.T, c Config)
+		err   string
+	}{
+		{
+			name: "default auth needs keys",
+			err:  "auth is on by default",
+		},
+		{
+			name: "defaults with auth disabled",
+			args: []string{"-auth-disabled"},
+			check: func(t *testing.T, c Config) {
+				want := Default()
+				if c.Listen != want.Listen || c.Mongo.URI != want.Mongo.URI || c.Upload != want.Upload || !c.Auth.Disabled {
+					t.Errorf("config = %+v", c)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: "listen: 127.0.0.1:9000\nauth:\n  disabled: true\nmongo:\n  pool_size: 10\n",
+			check: func(t *testing.T, c Config) {
+				if c.Listen != "127.0.0.1:9000" || c.Mongo.PoolSize != 10 || c.Mongo.Database != "spotify" {
+					t.Errorf("config = %+v", c)
+				}
+			},
+		},
+		{
+			name: "env overrides file",
+			file: "listen: 127.0.0.1:9000\nauth:\n  disabled: true\n",
+			env:  map[string]string{"SPOTIFY_DB_LISTEN": "127.0.0.1:9001", "SPOTIFY_DB_MONGO_POOL_SIZE": "20"},
+			check: func(t *testing.T, c Config) {
+				if c.Listen != "127.0.0.1:9001" || c.Mongo.PoolSize != 20 {
+					t.Errorf("config = %+v", c)
+				}
+			},
+		},
+		{
+			name: "flag overrides env",
+			file: "listen: 127.0.0.1:9000\n",
+			env:  map[string]string{"SPOTIFY_DB_LISTEN": "127.0.0.1:9001", "SPOTIFY_DB_AUTH_DISABLED": "true"},
+			args: []string{"-listen", "127.0.0.1:9002", "-upload-session-ttl", "1h"},
+			check: func(t *testing.T, c Config) {
+				if c.Listen != "127.0.0.1:9002" || c.Upload.SessionTTL != time.Hour || !c.Auth.Disabled {
+					t.Errorf("config = %+v", c)
+				}
+			},
+		},
+		{
+			name: "config file from env",
+			env:  map[string]string{"SPOTIFY_DB_CONFIG": "listen: 127.0.0.1:9000\n"},
+			args: []string{"-auth-disabled"},
+			check: func(t *testing.T, c Config) {
+				if c.Listen != "127.0.0.1:9000" {
+					t.Errorf("listen = %q", c.Listen)
+				}
+			},
+		},
+		{
+			name: "maps of file and flags are merged",
+			file: "auth:\n  service_tokens:\n    player: " + strings.Repeat("p", MinServiceTokenLength) + "\n",
+			args: []string{"-auth-service-tokens", "search=" + strings.Repeat("s", MinServiceTokenLength)},
+			check: func(t *testing.T, c Config) {
+				if len(c.Auth.ServiceTokens) != 2 || c.Auth.Disabled {
+					t.Errorf("service tokens = %v", c.Auth.ServiceTokens)
+				}
+			},
+		},
+		{
+			name: "unknown file key",
+			file: "listen: 127.0.0.1:9000\nlisten_port: 9000\n",
+			args: []string{"-auth-disabled"},
+			err:  "error parsing config file",
+		},
+		{
+			name: "missing file",
+			args: []string{"-config", "missing.yaml", "-auth-disabled"},
+			err:  "error reading config file",
+		},
+		{
+			name: "invalid env value",
+			env:  map[string]string{"SPOTIFY_DB_MONGO_POOL_SIZE": "many"},
+			args: []string{"-auth-disabled"},
+			err:  "invalid SPOTIFY_DB_MONGO_POOL_SIZE",
+		},
+		{
+			name: "invalid flag value",
+			args: []string{"-auth-disabled", "-shutdown-timeout", "soon"},
+			err:  "invalid -shutdown-timeout",
+		},
+		{
+			name: "loaded config is validated",
+			args: []string{"-auth-disabled", "-log-level", "trace"},
+			err:  "log level must be one of",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			for k, v := range tt.env {
+				if k == EnvName("config") {
+					v = writeFile(t, v)
+				}
+				t.Setenv(k, v)
+			}
+
+			c, err := Load(args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		// errs - substrings of validation error, config is valid when empty
+		errs []string
+	}{
+		{
+			name:   "auth disabled",
+			change: func(c *Config) {},
+		},
+		{
+			name:   "jwt secret",
+			change: func(c *Config) { c.Auth = Auth{JWTSecret: "secret"} },
+		},
+		{
+			name:   "auth without keys",
+			change: func(c *Config) { c.Auth = Auth{} },
+			errs:   []string{"auth is on by default"},
+		},
+		{
+			name:   "both jwt keys",
+			change: func(c *Config) { c.Auth = Auth{JWTSecret: "secret", JWTPublicKeyFile: "key.pem"} },
+			errs:   []string{"only one of auth jwt secret", "auth jwt public key file"},
+		},
+		{
+			name:   "short service token",
+			change: func(c *Config) { c.Auth = Auth{ServiceTokens: map[string]string{"player": "short"}} },
+			errs:   []string{"auth service token of player"},
+		},
+		{
+			name: "listen and timeouts",
+			change: func(c *Config) {
+				c.Listen = "8082"
+				c.ShutdownTimeout = 0
+				c.Timeouts.Request = -time.Second
+				c.Timeouts.Endpoints["api/v1/upload"] = -time.Second
+			},
+			errs: []string{"listen:", "shutdown timeout", "request timeout", "must start with /", "endpoint timeout of api/v1/upload"},
+		},
+		{
+			name: "upload limits",
+			change: func(c *Config) {
+				c.Upload.MaxSize = 0
+				c.Upload.CleanupInterval = 0
+			},
+			errs: []string{"upload max size", "cleanup interval"},
+		},
+		{
+			name: "log and tracing",
+			change: func(c *Config) {
+				c.Log.Level = "trace"
+				c.Tracing.Exporter = "jaeger"
+			},
+			errs: []string{"log level", "tracing exporter"},
+		},
+		{
+			name: "mongo",
+			change: func(c *Config) {
+				c.Mongo.URI = "localhost:27017"
+				c.Mongo.Password = "secret"
+				c.Mongo.Database = ""
+				c.Mongo.Collections.Songs = ""
+				c.Mongo.SegmentsBatchSize = 0
+			},
+			errs: []string{"mongo uri must start with", "password is set without username", "mongo database", "collection names", "segments batch size"},
+		},
+		{
+			name: "mongo is not checked in memory",
+			change: func(c *Config) {
+				c.InMemory = true
+				c.Mongo = Mongo{}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Auth.Disabled = true
+			tt.change(&c)
+
+			err := c.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("config is valid, want %q", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
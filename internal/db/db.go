@@ -1,205 +1,658 @@
 package db
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
 	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
 	"github.com/u2takey/go-utils/rand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/zap"
-	"gopkg.in/mgo.v2"
+	"os"
+	"time"
 )
 
 type obj map[string]interface{}
 
 type IDB interface {
-	GetAllSongs() (result []globalStructs.Song, err error)
-	GetSegment(id string) (result globalStructs.SongData, err error)
-	InsertSegment(ts ...globalStructs.SongData) error
-	InsertSong(s globalStructs.Song) error
-	GetSongByID(id string) (s globalStructs.Song, err error)
-	GetUserByID(id string) (resp globalStructs.User, err error)
-	NewUser(u globalStructs.User) error
-	NewPlaylist(p globalStructs.Playlist) error
-	DeleteUserPlaylist(id, owner string) error
-	DeletePlaylistByID(id string) error
-	GetPlaylistByID(id string) (p globalStructs.Playlist, err error)
-	AddSongsToUserPlaylist(id, owner string, song globalStructs.Song) error
-	AddSongsToPlaylist(id string, song globalStructs.Song) error
-	RemoveSongFromUserPlaylist(id, owner, songID string) error
-	RemoveSongFromPlaylist(id, songID string) error
-	GetAllUserPlaylists(owner string) (p []globalStructs.ShortPlaylist, err error)
+	GetAllSongs(ctx context.Context) (result []globalStructs.Song, err error)
+	GetSongs(ctx context.Context, q SongsQuery) (result []globalStructs.Song, total int, err error)
+	SearchSongs(ctx context.Context, q SearchQuery) (result []globalStructs.Song, total int, err error)
+	GetSegment(ctx context.Context, id string) (result globalStructs.SongData, err error)
+	GetSegmentInfo(ctx context.Context, id string) (result globalStructs.SongData, created time.Time, err error)
+	InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) error
+	FindSegmentIDs(ctx context.Context, ids ...string) (found []string, err error)
+	GetSegmentSongID(ctx context.Context, id string) (songID string, err error)
+	DeleteSegments(ctx context.Context, songID string, ids ...string) error
+	InsertSong(ctx context.Context, s globalStructs.Song) error
+	GetSongByID(ctx context.Context, id string) (s globalStructs.Song, err error)
+	DeleteSong(ctx context.Context, id string, legacy []string, dryRun bool) (result DeleteSongResult, err error)
+	UpdateSong(ctx context.Context, u SongUpdate) (playlists int, err error)
+	GetUserByID(ctx context.Context, id string) (resp globalStructs.User, err error)
+	GetUserRoles(ctx context.Context, id string) (roles []string, err error)
+	SetUserRoles(ctx context.Context, id string, roles []string) error
+	NewUser(ctx context.Context, u globalStructs.User) error
+	NewPlaylist(ctx context.Context, p globalStructs.Playlist) error
+	DeleteUserPlaylist(ctx context.Context, id, owner string) error
+	DeletePlaylistByID(ctx context.Context, id string) error
+	GetPlaylistByID(ctx context.Context, id string) (p globalStructs.Playlist, err error)
+	AddSongsToUserPlaylist(ctx context.Context, id, owner string, song globalStructs.Song) error
+	AddSongsToPlaylist(ctx context.Context, id string, song globalStructs.Song) error
+	RemoveSongFromUserPlaylist(ctx context.Context, id, owner, songID string) error
+	RemoveSongFromPlaylist(ctx context.Context, id, songID string) error
+	GetAllUserPlaylists(ctx context.Context, owner string) (p []globalStructs.ShortPlaylist, err error)
+	NewUploadSession(ctx context.Context, s UploadSession) (id string, err error)
+	GetUploadSession(ctx context.Context, id string) (s UploadSession, err error)
+	SetUploadSegment(ctx context.Context, id, key, segmentID string, expires time.Time) error
+	DeleteUploadSession(ctx context.Context, id string) error
+	ExpiredUploadSessions(ctx context.Context, now time.Time, limit int) (result []UploadSession, err error)
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
 }
 
 type DB struct {
-	Logger  *zap.Logger
-	Session *mgo.Session
+	Logger *zap.Logger
+	Client *mongo.Client
 
-	SegmentsCollection *mgo.Collection
-	SongsCollection    *mgo.Collection
-	UsersCollection    *mgo.Collection
-	PlaylistCollection *mgo.Collection
+	SegmentsCollection *mongo.Collection
+	SongsCollection    *mongo.Collection
+	UsersCollection    *mongo.Collection
+	PlaylistCollection *mongo.Collection
+	UploadsCollection  *mongo.Collection
+
+	// SegmentsBatchSize - max number of segments in one bulk insert
+	SegmentsBatchSize int
+	// Timeout - deadline of every db call, 0 means no deadline
+	Timeout time.Duration
 }
 
 const GetAllSongsLimit = 1000
 
-func NewDB(dbname string, logger *zap.Logger) (IDB, error) {
-	session, err := mgo.Dial("")
+// UserFieldRoles - field of users collection with roles of the user, it is not part of
+// globalStructs.User so it is only read and written by GetUserRoles and SetUserRoles
+const UserFieldRoles = "roles"
+
+// PlaylistFieldOwner - field of playlists collection with id of the user who owns the playlist
+const PlaylistFieldOwner = "owner_id"
+
+var (
+	// ErrNotFound - document does not exist or update filter matched nothing
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate - document with the same _id already exists, check with IsDup
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrForbidden - returned by user playlist methods when playlist exists but belongs to another user
+	ErrForbidden = errors.New("playlist belongs to another user")
+	// ErrConflict - returned by SetUploadSegment when another segment is already recorded under the key
+	ErrConflict = errors.New("conflict")
+)
+
+// IsDup - checks if err is a duplicate key error of any IDB implementation,
+// *InsertSegmentsError is a duplicate error when every failed segment is a duplicate
+func IsDup(err error) bool {
+	var ierr *InsertSegmentsError
+	if errors.As(err, &ierr) {
+		for _, v := range ierr.Failed {
+			if !IsDup(v.Err) {
+				return false
+			}
+		}
+		return len(ierr.Failed) != 0
+	}
+	return errors.Is(err, ErrDuplicate) || mongo.IsDuplicateKeyError(err)
+}
+
+// NewDB - connects to mongo with given settings
+func NewDB(cfg config.Mongo, logger *zap.Logger) (IDB, error) {
+	opts, err := clientOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	db := client.Database(cfg.Database)
+	segments := db.Collection(cfg.Collections.Segments)
+	_, err = segments.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "song_id", Value: 1}}})
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	songs := db.Collection(cfg.Collections.Songs)
+	_, err = songs.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: SongFieldUploaded, Value: 1}, {Key: "_id", Value: 1}}})
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	uploads := db.Collection(cfg.Collections.UploadSessions)
+	_, err = uploads.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: UploadFieldExpires, Value: 1}}})
 	if err != nil {
+		client.Disconnect(context.Background())
 		return nil, err
 	}
+
 	return &DB{
 		Logger:             logger,
-		Session:            session,
-		SegmentsCollection: session.DB(dbname).C("segments"),
-		SongsCollection:    session.DB(dbname).C("songs"),
-		UsersCollection:    session.DB(dbname).C("users"),
-		PlaylistCollection: session.DB(dbname).C("playlists"),
+		Client:             client,
+		SegmentsCollection: segments,
+		SongsCollection:    songs,
+		UsersCollection:    db.Collection(cfg.Collections.Users),
+		PlaylistCollection: db.Collection(cfg.Collections.Playlists),
+		UploadsCollection:  uploads,
+		SegmentsBatchSize:  cfg.SegmentsBatchSize,
+		Timeout:            cfg.SocketTimeout,
 	}, nil
 }
 
+// clientOptions - driver options from uri with credentials, timeouts and tls from config on top of it
+func clientOptions(cfg config.Mongo) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(cfg.URI).SetConnectTimeout(cfg.ConnectTimeout)
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("error parsing mongo uri: %w", err)
+	}
+
+	if cfg.SocketTimeout > 0 {
+		opts.SetSocketTimeout(cfg.SocketTimeout)
+	}
+	if cfg.PoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(cfg.PoolSize))
+	}
+	if cfg.Username != "" {
+		cred := options.Credential{Username: cfg.Username, Password: cfg.Password}
+		if opts.Auth != nil {
+			cred.AuthSource = opts.Auth.AuthSource
+			cred.AuthMechanism = opts.Auth.AuthMechanism
+		}
+		opts.SetAuth(cred)
+	}
+	if cfg.AuthSource != "" && opts.Auth != nil {
+		opts.Auth.AuthSource = cfg.AuthSource
+	}
+
+	if !cfg.TLS.Enabled {
+		return opts, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading mongo ca file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("mongo ca file has no certificates")
+		}
+	}
+	return opts.SetTLSConfig(tlsConfig), nil
+}
+
+// ctx - context of a single db call derived from caller context, limited by Timeout
+func (d *DB) ctx(parent context.Context) (context.Context, context.CancelFunc) {
+	if d.Timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, d.Timeout)
+}
+
+// log - request logger of ctx, it carries request id
+func (d *DB) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, d.Logger)
+}
+
+// Ping - checks that primary is reachable
+func (d *DB) Ping(ctx context.Context) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return d.Client.Ping(ctx, readpref.Primary())
+}
+
+// Close - disconnects from mongo, waits for in-use connections to be returned until ctx is done
+func (d *DB) Close(ctx context.Context) error {
+	return d.Client.Disconnect(ctx)
+}
+
+// dbError - converts driver errors to package errors
+func dbError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", ErrDuplicate, err)
+	}
+	return err
+}
+
+// findOne - decodes document matching filter into out
+func findOne(ctx context.Context, c *mongo.Collection, filter interface{}, out interface{}, opts ...*options.FindOneOptions) error {
+	return dbError(c.FindOne(ctx, filter, opts...).Decode(out))
+}
+
+// findIDs - ids of all documents matching filter
+func findIDs(ctx context.Context, c *mongo.Collection, filter interface{}) ([]string, error) {
+	cur, err := c.Find(ctx, filter, options.Find().SetProjection(obj{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(docs))
+	for _, v := range docs {
+		ids = append(ids, v.ID)
+	}
+	return ids, nil
+}
+
+// updateOne - updates document matching filter, returns ErrNotFound if nothing matched
+func updateOne(ctx context.Context, c *mongo.Collection, filter, update interface{}) error {
+	res, err := c.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return dbError(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deleteOne - removes document matching filter, returns ErrNotFound if nothing matched
+func deleteOne(ctx context.Context, c *mongo.Collection, filter interface{}) error {
+	res, err := c.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // GetAllSongs - limit for 1000
-func (d *DB) GetAllSongs() (result []globalStructs.Song, err error) {
-	err = d.SongsCollection.Find(obj{}).Limit(GetAllSongsLimit).All(&result)
+func (d *DB) GetAllSongs(ctx context.Context) (result []globalStructs.Song, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	cur, err := d.SongsCollection.Find(ctx, obj{}, options.Find().SetLimit(GetAllSongsLimit))
+	if err != nil {
+		return nil, err
+	}
+	err = cur.All(ctx, &result)
 	return
 }
 
-func (d *DB) GetSegment(id string) (result globalStructs.SongData, err error) {
-	err = d.SegmentsCollection.Find(obj{"_id": id}).One(&result)
+// GetSongs - returns filtered and sorted page of songs with total number of songs matching filter
+func (d *DB) GetSongs(ctx context.Context, q SongsQuery) (result []globalStructs.Song, total int, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	filter := q.selector()
+	count, err := d.SongsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return
+	}
+	total = int(count)
+
+	opts := options.Find().SetSort(q.sort()).SetSkip(int64(q.Offset))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cur, err := d.SongsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	err = cur.All(ctx, &result)
 	return
 }
 
-func (d *DB) InsertSegment(ts ...globalStructs.SongData) error {
-	for _, v := range ts {
-		err := d.SegmentsCollection.Insert(v)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+func (d *DB) GetSegment(ctx context.Context, id string) (result globalStructs.SongData, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err = findOne(ctx, d.SegmentsCollection, obj{"_id": id}, &result)
+	return
 }
 
-func (d *DB) InsertSong(s globalStructs.Song) error {
-	err := d.SongsCollection.Insert(s)
+// FindSegmentIDs - returns ids of segments from given list which are already stored
+func (d *DB) FindSegmentIDs(ctx context.Context, ids ...string) (found []string, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return findIDs(ctx, d.SegmentsCollection, obj{"_id": obj{"$in": ids}})
+}
+
+// DeleteSegments - removes segments of song with given ids, missing ids and segments of other
+// songs with the same ids are ignored
+func (d *DB) DeleteSegments(ctx context.Context, songID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	_, err := d.SegmentsCollection.DeleteMany(ctx, obj{"_id": obj{"$in": ids}, "song_id": songID})
 	return err
 }
 
-func (d *DB) GetSongByID(id string) (s globalStructs.Song, err error) {
-	err = d.SongsCollection.Find(obj{"_id": id}).One(&s)
+func (d *DB) InsertSong(ctx context.Context, s globalStructs.Song) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	_, err := d.SongsCollection.InsertOne(ctx, songDoc{Song: s, Uploaded: time.Now().UTC()})
+	return dbError(err)
+}
+
+func (d *DB) GetSongByID(ctx context.Context, id string) (s globalStructs.Song, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err = findOne(ctx, d.SongsCollection, obj{"_id": id}, &s)
 	return
 }
 
-//func (d *DB) DeleteSongByID(id string) (s globalStructs.Song)
+// UpdateSong - sets fields of u in song document and every copy of it embedded in playlists,
+// other fields are kept. Returns number of updated playlists
+func (d *DB) UpdateSong(ctx context.Context, u SongUpdate) (playlists int, err error) {
+	if u.ID == "" {
+		return 0, errors.New("id must not be empty")
+	}
+	if len(u.fields()) == 0 {
+		return 0, errors.New("nothing to update")
+	}
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
 
-func (d *DB) NewUser(u globalStructs.User) error {
-	err := d.UsersCollection.Insert(u)
-	return err
+	if err = updateOne(ctx, d.SongsCollection, obj{"_id": u.ID}, obj{"$set": u.set("")}); err != nil {
+		return 0, err
+	}
+
+	res, err := d.PlaylistCollection.UpdateMany(ctx,
+		obj{"songs._id": u.ID},
+		obj{"$set": u.set("songs.$[song].")},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{obj{"song._id": u.ID}},
+		}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
 }
 
-func (d *DB) GetUserByID(id string) (resp globalStructs.User, err error) {
-	err = d.UsersCollection.Find(obj{"_id": id}).One(&resp)
+// DeleteSong - removes song from every playlist, then its segments and the song itself,
+// so failed delete can be retried. Segments stored before they had song_id are found only
+// by legacy ids. With dryRun nothing is removed, only result is returned
+func (d *DB) DeleteSong(ctx context.Context, id string, legacy []string, dryRun bool) (result DeleteSongResult, err error) {
+	if id == "" {
+		return result, errors.New("id must not be empty")
+	}
+
+	if _, err = d.GetSongByID(ctx, id); err != nil {
+		return
+	}
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	segments := obj{"song_id": id}
+	if len(legacy) != 0 {
+		segments = obj{"$or": []obj{
+			{"song_id": id},
+			{"_id": obj{"$in": legacy}, "song_id": obj{"$in": []interface{}{nil, ""}}},
+		}}
+	}
+
+	if result.Segments, err = findIDs(ctx, d.SegmentsCollection, segments); err != nil {
+		return
+	}
+	if result.Playlists, err = findIDs(ctx, d.PlaylistCollection, obj{"songs._id": id}); err != nil {
+		return
+	}
+
+	if dryRun {
+		return
+	}
+
+	pulled, err := d.PlaylistCollection.UpdateMany(ctx, obj{"songs._id": id}, obj{
+		"$pull": obj{
+			"songs": obj{"_id": id},
+		},
+	})
+	if err != nil {
+		return
+	}
+	result.Partial = pulled.ModifiedCount != 0
+
+	deleted, err := d.SegmentsCollection.DeleteMany(ctx, segments)
+	if err != nil {
+		return
+	}
+	result.Partial = result.Partial || deleted.DeletedCount != 0
+
+	if err = deleteOne(ctx, d.SongsCollection, obj{"_id": id}); err != nil {
+		return
+	}
+	result.Partial = false
 	return
 }
 
-func (d *DB) NewPlaylist(p globalStructs.Playlist) error {
-	p.ID = rand.String(24)
-	err := d.PlaylistCollection.Insert(p)
-	if mgo.IsDup(err) {
-		return d.NewPlaylist(p)
+func (d *DB) NewUser(ctx context.Context, u globalStructs.User) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	_, err := d.UsersCollection.InsertOne(ctx, u)
+	return dbError(err)
+}
+
+func (d *DB) GetUserByID(ctx context.Context, id string) (resp globalStructs.User, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err = findOne(ctx, d.UsersCollection, obj{"_id": id}, &resp)
+	return
+}
+
+// userRoles - roles field of user document
+type userRoles struct {
+	Roles []string `bson:"roles"`
+}
+
+// GetUserRoles - roles stored for the user, empty for users without roles
+func (d *DB) GetUserRoles(ctx context.Context, id string) (roles []string, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	var u userRoles
+	err = findOne(ctx, d.UsersCollection, obj{"_id": id}, &u, options.FindOne().SetProjection(obj{UserFieldRoles: 1}))
+	return u.Roles, err
+}
+
+// SetUserRoles - replaces roles of the user
+func (d *DB) SetUserRoles(ctx context.Context, id string, roles []string) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return updateOne(ctx, d.UsersCollection, obj{"_id": id}, obj{"$set": obj{UserFieldRoles: roles}})
+}
+
+func (d *DB) NewPlaylist(ctx context.Context, p globalStructs.Playlist) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	for {
+		p.ID = rand.String(24)
+		_, err := d.PlaylistCollection.InsertOne(ctx, p)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
 	}
-	return err
 }
 
-func (d *DB) DeleteUserPlaylist(id, owner string) error {
+// DeleteUserPlaylist - removes playlist if it belongs to owner,
+// returns ErrForbidden if playlist belongs to someone else
+func (d *DB) DeleteUserPlaylist(ctx context.Context, id, owner string) error {
 	if id == "" || owner == "" {
 		return errors.New("id and owner must not be empty")
 	}
-	err := d.PlaylistCollection.Remove(obj{"_id": id, "owner": owner})
-	return err
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err := deleteOne(ctx, d.PlaylistCollection, obj{"_id": id, PlaylistFieldOwner: owner})
+	return d.playlistError(ctx, id, owner, err)
 }
 
-func (d *DB) DeletePlaylistByID(id string) error {
+func (d *DB) DeletePlaylistByID(ctx context.Context, id string) error {
 	if id == "" {
 		return errors.New("id must not be empty")
 	}
-	err := d.PlaylistCollection.Remove(obj{"_id": id})
-	return err
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return deleteOne(ctx, d.PlaylistCollection, obj{"_id": id})
 }
 
-func (d *DB) GetAllUserPlaylists(owner string) (p []globalStructs.ShortPlaylist, err error) {
-	err = d.PlaylistCollection.Find(obj{"owner_id": owner}).All(&p)
+func (d *DB) GetAllUserPlaylists(ctx context.Context, owner string) (p []globalStructs.ShortPlaylist, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	cur, err := d.PlaylistCollection.Find(ctx, obj{PlaylistFieldOwner: owner})
+	if err != nil {
+		return nil, err
+	}
+	err = cur.All(ctx, &p)
 	return
 }
 
-func (d *DB) GetPlaylistByID(id string) (p globalStructs.Playlist, err error) {
+func (d *DB) GetPlaylistByID(ctx context.Context, id string) (p globalStructs.Playlist, err error) {
 	if id == "" {
 		return p, errors.New("id must not be empty")
 	}
-	err = d.PlaylistCollection.Find(obj{"_id": id}).One(&p)
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err = findOne(ctx, d.PlaylistCollection, obj{"_id": id}, &p)
 	return
 }
 
-// AddSongsToUserPlaylist - adds songs to playlist linked with user
-func (d *DB) AddSongsToUserPlaylist(id, owner string, song globalStructs.Song) error {
+// AddSongsToUserPlaylist - adds songs to playlist linked with user,
+// returns ErrForbidden if playlist belongs to someone else
+func (d *DB) AddSongsToUserPlaylist(ctx context.Context, id, owner string, song globalStructs.Song) error {
 	if id == "" || owner == "" || song.ID == "" {
 		return errors.New("id and owner must not be empty")
 	}
 
-	err := d.PlaylistCollection.Update(obj{
-		"_id":      id,
-		"owner_id": owner,
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err := updateOne(ctx, d.PlaylistCollection, obj{
+		"_id":              id,
+		PlaylistFieldOwner: owner,
 	}, obj{
 		"$push": obj{"songs": song},
 	})
 
-	return err
+	return d.playlistError(ctx, id, owner, err)
 }
 
 // AddSongsToPlaylist - adds songs to playlist
-func (d *DB) AddSongsToPlaylist(id string, song globalStructs.Song) error {
+func (d *DB) AddSongsToPlaylist(ctx context.Context, id string, song globalStructs.Song) error {
 	if id == "" || song.ID == "" {
 		return errors.New("id and owner must not be empty")
 	}
 
-	err := d.PlaylistCollection.Update(obj{
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return updateOne(ctx, d.PlaylistCollection, obj{
 		"_id": id,
 	}, obj{
 		"$push": obj{"songs": song},
 	})
-
-	return err
 }
 
-// RemoveSongFromUserPlaylist - sends req to mongo to find and remove song by id from songs slice
-func (d *DB) RemoveSongFromUserPlaylist(id, owner, songID string) error {
+// RemoveSongFromUserPlaylist - sends req to mongo to find and remove song by id from songs slice,
+// returns ErrForbidden if playlist belongs to someone else and ErrNotFound if there is no such song in it
+func (d *DB) RemoveSongFromUserPlaylist(ctx context.Context, id, owner, songID string) error {
 	if id == "" || owner == "" || songID == "" {
 		return errors.New("id and owner must not be empty")
 	}
 
-	return d.PlaylistCollection.Update(obj{
-		"_id":      id,
-		"owner_id": owner,
-		"songs": obj{
-			"_id": songID,
-		},
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err := updateOne(ctx, d.PlaylistCollection, obj{
+		"_id":              id,
+		PlaylistFieldOwner: owner,
+		"songs._id":        songID,
 	}, obj{
 		"$pull": obj{
 			"songs": obj{"_id": songID},
 		},
 	})
+	return d.playlistError(ctx, id, owner, err)
 }
 
-func (d *DB) RemoveSongFromPlaylist(id, songID string) error {
+func (d *DB) RemoveSongFromPlaylist(ctx context.Context, id, songID string) error {
 	if id == "" || songID == "" {
 		return errors.New("id and songID must not be empty")
 	}
 
-	return d.PlaylistCollection.Update(obj{
-		"_id": id,
-		"songs": obj{
-			"_id": songID,
-		},
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return updateOne(ctx, d.PlaylistCollection, obj{
+		"_id":       id,
+		"songs._id": songID,
 	}, obj{
 		"$pull": obj{
 			"songs": obj{"_id": songID},
 		},
 	})
 }
+
+// playlistError - tells apart missing playlist and playlist of another user when update or remove
+// filtered by owner matched nothing, other errors are returned as is
+func (d *DB) playlistError(ctx context.Context, id, owner string, err error) error {
+	if err != ErrNotFound {
+		return err
+	}
+
+	var p struct {
+		OwnerID string `bson:"owner_id"`
+	}
+	opts := options.FindOne().SetProjection(obj{PlaylistFieldOwner: 1})
+	if err := findOne(ctx, d.PlaylistCollection, obj{"_id": id}, &p, opts); err != nil {
+		return err
+	}
+	if p.OwnerID != owner {
+		return ErrForbidden
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,852 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"github.com/u2takey/go-utils/rand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// MemoryDB - in-memory implementation of IDB, used in tests and for local development.
+// Documents are stored bson encoded so reads and writes behave the same way as with mongo,
+// not found errors are ErrNotFound and duplicate keys are reported so that IsDup works
+type MemoryDB struct {
+	Logger *zap.Logger
+
+	mut       sync.RWMutex
+	segments  *memCollection
+	songs     *memCollection
+	users     *memCollection
+	playlists *memCollection
+	uploads   *memCollection
+}
+
+func NewMemoryDB(logger *zap.Logger) IDB {
+	return &MemoryDB{
+		Logger:    logger,
+		segments:  newMemCollection("segments"),
+		songs:     newMemCollection("songs"),
+		users:     newMemCollection("users"),
+		playlists: newMemCollection("playlists"),
+		uploads:   newMemCollection("upload_sessions"),
+	}
+}
+
+// Ping - in-memory db is always reachable
+func (d *MemoryDB) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close - nothing to release, data is kept until MemoryDB is garbage collected
+func (d *MemoryDB) Close(ctx context.Context) error {
+	return nil
+}
+
+// memCollection - stores bson documents by _id keeping insertion order like mongo natural order
+type memCollection struct {
+	name  string
+	docs  map[string][]byte
+	order []string
+}
+
+func newMemCollection(name string) *memCollection {
+	return &memCollection{name: name, docs: map[string][]byte{}}
+}
+
+func (c *memCollection) insert(doc interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var key struct {
+		ID string `bson:"_id"`
+	}
+	if err := bson.Unmarshal(data, &key); err != nil {
+		return err
+	}
+
+	if _, ok := c.docs[key.ID]; ok {
+		return fmt.Errorf("%w: collection %s dup key %s", ErrDuplicate, c.name, key.ID)
+	}
+
+	c.docs[key.ID] = data
+	c.order = append(c.order, key.ID)
+	return nil
+}
+
+func (c *memCollection) replace(id string, doc interface{}) error {
+	if _, ok := c.docs[id]; !ok {
+		return ErrNotFound
+	}
+
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	c.docs[id] = data
+	return nil
+}
+
+func (c *memCollection) find(id string, out interface{}) error {
+	data, ok := c.docs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return bson.Unmarshal(data, out)
+}
+
+func (c *memCollection) remove(id string) error {
+	if _, ok := c.docs[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(c.docs, id)
+	for i, v := range c.order {
+		if v == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// each - calls f with raw document in insertion order until f returns false
+func (c *memCollection) each(f func(id string, data []byte) bool) {
+	for _, id := range c.order {
+		if !f(id, c.docs[id]) {
+			return
+		}
+	}
+}
+
+// GetAllSongs - limit for 1000
+func (d *MemoryDB) GetAllSongs(ctx context.Context) (result []globalStructs.Song, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	d.songs.each(func(_ string, data []byte) bool {
+		var s globalStructs.Song
+		if err = bson.Unmarshal(data, &s); err != nil {
+			return false
+		}
+		result = append(result, s)
+		return len(result) < GetAllSongsLimit
+	})
+	return
+}
+
+// GetSongs - returns filtered and sorted page of songs with total number of songs matching filter
+func (d *MemoryDB) GetSongs(ctx context.Context, q SongsQuery) (result []globalStructs.Song, total int, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	var docs []bson.M
+	var raw [][]byte
+	d.songs.each(func(_ string, data []byte) bool {
+		var doc bson.M
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return false
+		}
+		if q.match(doc) {
+			docs = append(docs, doc)
+			raw = append(raw, data)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	idx := make([]int, len(docs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return q.less(docs[idx[i]], docs[idx[j]])
+	})
+
+	total = len(idx)
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+	idx = idx[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(idx) {
+		idx = idx[:q.Limit]
+	}
+
+	for _, i := range idx {
+		var s globalStructs.Song
+		if err = bson.Unmarshal(raw[i], &s); err != nil {
+			return nil, 0, err
+		}
+		result = append(result, s)
+	}
+	return
+}
+
+// SearchSongs - finds songs by name, artist and album, results are ranked by search.Rank,
+// songs of equal rank are ordered like in DB
+func (d *MemoryDB) SearchSongs(ctx context.Context, q SearchQuery) (result []globalStructs.Song, total int, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	var docs []bson.M
+	var raw []bson.Raw
+	d.songs.each(func(_ string, data []byte) bool {
+		var doc bson.M
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return false
+		}
+		docs = append(docs, doc)
+		raw = append(raw, bson.Raw(data))
+		return true
+	})
+	d.mut.RUnlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	idx := make([]int, len(docs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return searchOrder.less(docs[idx[i]], docs[idx[j]])
+	})
+	sorted := make([]bson.Raw, len(idx))
+	for i, v := range idx {
+		sorted[i] = raw[v]
+	}
+
+	return rankSongs(q, sorted)
+}
+
+func (d *MemoryDB) GetSegment(ctx context.Context, id string) (result globalStructs.SongData, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	err = d.segments.find(id, &result)
+	return
+}
+
+// GetSegmentSongID - id of the song segment was inserted for
+func (d *MemoryDB) GetSegmentSongID(ctx context.Context, id string) (songID string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	var doc segmentDoc
+	err = d.segments.find(id, &doc)
+	return doc.SongID, err
+}
+
+// GetSegmentInfo - returns segment with time it was inserted
+func (d *MemoryDB) GetSegmentInfo(ctx context.Context, id string) (result globalStructs.SongData, created time.Time, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	var doc segmentDoc
+	err = d.segments.find(id, &doc)
+	return doc.SongData, doc.Created, err
+}
+
+// InsertSegment - inserts all segments of the song it can like unordered bulk insert,
+// returns *InsertSegmentsError describing every failed segment
+func (d *MemoryDB) InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var result InsertSegmentsError
+	now := time.Now().UTC()
+	for i, v := range ts {
+		if err := d.segments.insert(segmentDoc{SongData: v, SongID: songID, Created: now}); err != nil {
+			result.Failed = append(result.Failed, SegmentError{Index: i, ID: v.ID, Err: err})
+			continue
+		}
+		result.Inserted++
+	}
+	if len(result.Failed) != 0 {
+		return &result
+	}
+	return nil
+}
+
+// FindSegmentIDs - returns ids of segments from given list which are already stored
+func (d *MemoryDB) FindSegmentIDs(ctx context.Context, ids ...string) (found []string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	for _, id := range ids {
+		if _, ok := d.segments.docs[id]; ok {
+			found = append(found, id)
+		}
+	}
+	return
+}
+
+// DeleteSegments - removes segments of song with given ids, missing ids and segments of other
+// songs with the same ids are ignored
+func (d *MemoryDB) DeleteSegments(ctx context.Context, songID string, ids ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for _, id := range ids {
+		var doc segmentDoc
+		err := d.segments.find(id, &doc)
+		if err == ErrNotFound || (err == nil && doc.SongID != songID) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.segments.remove(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *MemoryDB) InsertSong(ctx context.Context, s globalStructs.Song) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return d.songs.insert(songDoc{Song: s, Uploaded: time.Now().UTC()})
+}
+
+func (d *MemoryDB) GetSongByID(ctx context.Context, id string) (s globalStructs.Song, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	err = d.songs.find(id, &s)
+	return
+}
+
+// UpdateSong - sets fields of u in song document and every copy of it embedded in playlists,
+// other fields are kept. Returns number of updated playlists
+func (d *MemoryDB) UpdateSong(ctx context.Context, u SongUpdate) (playlists int, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if u.ID == "" {
+		return 0, errors.New("id must not be empty")
+	}
+	if len(u.fields()) == 0 {
+		return 0, errors.New("nothing to update")
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	// raw document keeps fields which are not part of globalStructs.Song
+	var doc bson.M
+	if err = d.songs.find(u.ID, &doc); err != nil {
+		return
+	}
+	for field, v := range u.fields() {
+		doc[field] = v
+	}
+	if err = d.songs.replace(u.ID, doc); err != nil {
+		return
+	}
+
+	updated := map[string]globalStructs.Playlist{}
+	d.playlists.each(func(id string, data []byte) bool {
+		var p globalStructs.Playlist
+		if err = bson.Unmarshal(data, &p); err != nil {
+			return false
+		}
+		for i, v := range p.Songs {
+			if v.ID == u.ID {
+				u.apply(&p.Songs[i])
+				updated[id] = p
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	for id, p := range updated {
+		if err = d.playlists.replace(id, p); err != nil {
+			return
+		}
+	}
+	return len(updated), nil
+}
+
+// DeleteSong - removes song from every playlist, then its segments and the song itself.
+// Segments stored without song_id are found only by legacy ids. With dryRun nothing is removed,
+// only result is returned
+func (d *MemoryDB) DeleteSong(ctx context.Context, id string, legacy []string, dryRun bool) (result DeleteSongResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if id == "" {
+		return result, errors.New("id must not be empty")
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if _, ok := d.songs.docs[id]; !ok {
+		return result, ErrNotFound
+	}
+
+	d.segments.each(func(segmentID string, data []byte) bool {
+		var doc segmentDoc
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return false
+		}
+		if doc.SongID == id || (doc.SongID == "" && contains(legacy, segmentID)) {
+			result.Segments = append(result.Segments, segmentID)
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	playlists := map[string]globalStructs.Playlist{}
+	d.playlists.each(func(playlistID string, data []byte) bool {
+		var p globalStructs.Playlist
+		if err = bson.Unmarshal(data, &p); err != nil {
+			return false
+		}
+		if pullSong(&p, id) {
+			result.Playlists = append(result.Playlists, playlistID)
+			playlists[playlistID] = p
+		}
+		return true
+	})
+	if err != nil || dryRun {
+		return
+	}
+
+	for _, playlistID := range result.Playlists {
+		if err = d.playlists.replace(playlistID, playlists[playlistID]); err != nil {
+			return
+		}
+		result.Partial = true
+	}
+	for _, segmentID := range result.Segments {
+		if err = d.segments.remove(segmentID); err != nil {
+			return
+		}
+		result.Partial = true
+	}
+	if err = d.songs.remove(id); err != nil {
+		return
+	}
+	result.Partial = false
+	return
+}
+
+func (d *MemoryDB) NewUser(ctx context.Context, u globalStructs.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return d.users.insert(u)
+}
+
+func (d *MemoryDB) GetUserByID(ctx context.Context, id string) (resp globalStructs.User, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	err = d.users.find(id, &resp)
+	return
+}
+
+// GetUserRoles - roles stored for the user, empty for users without roles
+func (d *MemoryDB) GetUserRoles(ctx context.Context, id string) (roles []string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	var u userRoles
+	err = d.users.find(id, &u)
+	return u.Roles, err
+}
+
+// SetUserRoles - replaces roles of the user
+func (d *MemoryDB) SetUserRoles(ctx context.Context, id string, roles []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var u bson.M
+	if err := d.users.find(id, &u); err != nil {
+		return err
+	}
+	u[UserFieldRoles] = roles
+	return d.users.replace(id, u)
+}
+
+func (d *MemoryDB) NewPlaylist(ctx context.Context, p globalStructs.Playlist) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for {
+		p.ID = rand.String(24)
+		err := d.playlists.insert(p)
+		if !IsDup(err) {
+			return err
+		}
+	}
+}
+
+// DeleteUserPlaylist - removes playlist if it belongs to owner,
+// returns ErrForbidden if playlist belongs to someone else
+func (d *MemoryDB) DeleteUserPlaylist(ctx context.Context, id, owner string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" || owner == "" {
+		return errors.New("id and owner must not be empty")
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var p globalStructs.Playlist
+	if err := d.playlists.find(id, &p); err != nil {
+		return err
+	}
+	if p.OwnerID != owner {
+		return ErrForbidden
+	}
+	return d.playlists.remove(id)
+}
+
+func (d *MemoryDB) DeletePlaylistByID(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" {
+		return errors.New("id must not be empty")
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return d.playlists.remove(id)
+}
+
+func (d *MemoryDB) GetAllUserPlaylists(ctx context.Context, owner string) (p []globalStructs.ShortPlaylist, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	d.playlists.each(func(_ string, data []byte) bool {
+		var full globalStructs.Playlist
+		if err = bson.Unmarshal(data, &full); err != nil {
+			return false
+		}
+		if full.OwnerID != owner {
+			return true
+		}
+
+		var short globalStructs.ShortPlaylist
+		if err = bson.Unmarshal(data, &short); err != nil {
+			return false
+		}
+		p = append(p, short)
+		return true
+	})
+	return
+}
+
+func (d *MemoryDB) GetPlaylistByID(ctx context.Context, id string) (p globalStructs.Playlist, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if id == "" {
+		return p, errors.New("id must not be empty")
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	err = d.playlists.find(id, &p)
+	return
+}
+
+// AddSongsToUserPlaylist - adds songs to playlist linked with user,
+// returns ErrForbidden if playlist belongs to someone else
+func (d *MemoryDB) AddSongsToUserPlaylist(ctx context.Context, id, owner string, song globalStructs.Song) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" || owner == "" || song.ID == "" {
+		return errors.New("id and owner must not be empty")
+	}
+
+	return d.updatePlaylist(id, func(p *globalStructs.Playlist) error {
+		if p.OwnerID != owner {
+			return ErrForbidden
+		}
+		p.Songs = append(p.Songs, song)
+		return nil
+	})
+}
+
+// AddSongsToPlaylist - adds songs to playlist
+func (d *MemoryDB) AddSongsToPlaylist(ctx context.Context, id string, song globalStructs.Song) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" || song.ID == "" {
+		return errors.New("id and owner must not be empty")
+	}
+
+	return d.updatePlaylist(id, func(p *globalStructs.Playlist) error {
+		p.Songs = append(p.Songs, song)
+		return nil
+	})
+}
+
+// RemoveSongFromUserPlaylist - finds playlist owned by user and removes song by id from songs slice,
+// returns ErrForbidden if playlist belongs to someone else and ErrNotFound if there is no such song in it
+func (d *MemoryDB) RemoveSongFromUserPlaylist(ctx context.Context, id, owner, songID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" || owner == "" || songID == "" {
+		return errors.New("id and owner must not be empty")
+	}
+
+	return d.updatePlaylist(id, func(p *globalStructs.Playlist) error {
+		if p.OwnerID != owner {
+			return ErrForbidden
+		}
+		if !pullSong(p, songID) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (d *MemoryDB) RemoveSongFromPlaylist(ctx context.Context, id, songID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" || songID == "" {
+		return errors.New("id and songID must not be empty")
+	}
+
+	return d.updatePlaylist(id, func(p *globalStructs.Playlist) error {
+		if !pullSong(p, songID) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// updatePlaylist - applies f to playlist and stores the result, if f returns error
+// playlist is left untouched and the error is returned
+func (d *MemoryDB) updatePlaylist(id string, f func(p *globalStructs.Playlist) error) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var p globalStructs.Playlist
+	if err := d.playlists.find(id, &p); err != nil {
+		return err
+	}
+	if err := f(&p); err != nil {
+		return err
+	}
+	return d.playlists.replace(id, p)
+}
+
+// pullSong - removes all songs with given id, returns false if there were none
+func pullSong(p *globalStructs.Playlist, songID string) bool {
+	songs := p.Songs[:0]
+	for _, v := range p.Songs {
+		if v.ID != songID {
+			songs = append(songs, v)
+		}
+	}
+	found := len(songs) != len(p.Songs)
+	p.Songs = songs
+	return found
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUploadSession - inserts session with new random id and returns the id
+func (d *MemoryDB) NewUploadSession(ctx context.Context, s UploadSession) (id string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for {
+		s.ID = rand.String(24)
+		err := d.uploads.insert(s)
+		if !IsDup(err) {
+			return s.ID, err
+		}
+	}
+}
+
+func (d *MemoryDB) GetUploadSession(ctx context.Context, id string) (s UploadSession, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	err = d.uploads.find(id, &s)
+	return
+}
+
+// SetUploadSegment - records stored segment under key and moves expiry of the session,
+// returns ErrNotFound if session does not exist or has already expired and ErrConflict if
+// another segment is already recorded under key
+func (d *MemoryDB) SetUploadSegment(ctx context.Context, id, key, segmentID string, expires time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var s UploadSession
+	if err := d.uploads.find(id, &s); err != nil {
+		return err
+	}
+	if !s.Expires.After(time.Now()) {
+		return ErrNotFound
+	}
+	if stored, ok := s.Segments[key]; ok && stored != segmentID {
+		return ErrConflict
+	}
+	if s.Segments == nil {
+		s.Segments = map[string]string{}
+	}
+	s.Segments[key] = segmentID
+	s.Expires = expires
+	return d.uploads.replace(id, s)
+}
+
+func (d *MemoryDB) DeleteUploadSession(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return d.uploads.remove(id)
+}
+
+// ExpiredUploadSessions - up to limit sessions which expired before now
+func (d *MemoryDB) ExpiredUploadSessions(ctx context.Context, now time.Time, limit int) (result []UploadSession, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	d.uploads.each(func(id string, data []byte) bool {
+		var s UploadSession
+		if err = bson.Unmarshal(data, &s); err != nil {
+			return false
+		}
+		if !s.Expires.After(now) {
+			result = append(result, s)
+		}
+		return len(result) < limit
+	})
+	return
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"github.com/u2takey/go-utils/rand"
+	"go.uber.org/zap"
+)
+
+// mongoTestURIEnv - env variable with uri of mongo used by tests, tests of DB are skipped without it
+const mongoTestURIEnv = "MONGO_TEST_URI"
+
+// eachDB - runs f against MemoryDB and against DB backed by a fresh database
+// which is dropped after the test
+func eachDB(t *testing.T, f func(t *testing.T, d IDB)) {
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewMemoryDB(zap.NewNop()))
+	})
+	t.Run("mongo", func(t *testing.T) {
+		f(t, newTestDB(t))
+	})
+}
+
+func newTestDB(t *testing.T) IDB {
+	t.Helper()
+	uri := os.Getenv(mongoTestURIEnv)
+	if uri == "" {
+		t.Skip(mongoTestURIEnv + " is not set")
+	}
+
+	cfg := config.Default().Mongo
+	cfg.URI = uri
+	cfg.Database = "spotify_test_" + rand.String(8)
+	d, err := NewDB(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if err := d.(*DB).Client.Database(cfg.Database).Drop(ctx); err != nil {
+			t.Error(err)
+		}
+		d.Close(ctx)
+	})
+	return d
+}
+
+func TestPlaylists(t *testing.T) {
+	const owner, other = "alice", "bob"
+	song := globalStructs.Song{ID: "song", Name: "song"}
+	added := globalStructs.Song{ID: "added", Name: "added"}
+
+	tests := []struct {
+		name string
+		// call - playlist is id of playlist owner created before the call, it has song "song"
+		call func(ctx context.Context, d IDB, playlist string) error
+		err  error
+		// deleted - playlist must not exist after the call, songs - its songs otherwise
+		deleted bool
+		songs   []string
+	}{
+		{
+			name: "get playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				_, err := d.GetPlaylistByID(ctx, playlist)
+				return err
+			},
+			songs: []string{"song"},
+		},
+		{
+			name: "get missing playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				_, err := d.GetPlaylistByID(ctx, "missing")
+				return err
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "owner deletes playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.DeleteUserPlaylist(ctx, playlist, owner)
+			},
+			deleted: true,
+		},
+		{
+			name: "other user deletes playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.DeleteUserPlaylist(ctx, playlist, other)
+			},
+			err:   ErrForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "user deletes missing playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.DeleteUserPlaylist(ctx, "missing", owner)
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "delete playlist by id",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.DeletePlaylistByID(ctx, playlist)
+			},
+			deleted: true,
+		},
+		{
+			name: "delete missing playlist by id",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.DeletePlaylistByID(ctx, "missing")
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "owner adds song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.AddSongsToUserPlaylist(ctx, playlist, owner, added)
+			},
+			songs: []string{"song", "added"},
+		},
+		{
+			name: "other user adds song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.AddSongsToUserPlaylist(ctx, playlist, other, added)
+			},
+			err:   ErrForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "user adds song to missing playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.AddSongsToUserPlaylist(ctx, "missing", owner, added)
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "add song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.AddSongsToPlaylist(ctx, playlist, added)
+			},
+			songs: []string{"song", "added"},
+		},
+		{
+			name: "add song to missing playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.AddSongsToPlaylist(ctx, "missing", added)
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "owner removes song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromUserPlaylist(ctx, playlist, owner, "song")
+			},
+		},
+		{
+			name: "other user removes song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromUserPlaylist(ctx, playlist, other, "song")
+			},
+			err:   ErrForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "owner removes missing song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromUserPlaylist(ctx, playlist, owner, "missing")
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "user removes song from missing playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromUserPlaylist(ctx, "missing", owner, "song")
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "remove song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromPlaylist(ctx, playlist, "song")
+			},
+		},
+		{
+			name: "remove missing song",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromPlaylist(ctx, playlist, "missing")
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "remove song from missing playlist",
+			call: func(ctx context.Context, d IDB, playlist string) error {
+				return d.RemoveSongFromPlaylist(ctx, "missing", "song")
+			},
+			err:   ErrNotFound,
+			songs: []string{"song"},
+		},
+	}
+
+	eachDB(t, func(t *testing.T, d IDB) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				for _, p := range []globalStructs.Playlist{
+					{Name: "mine", OwnerID: owner, Songs: []globalStructs.Song{song}},
+					{Name: "theirs", OwnerID: other},
+				} {
+					if err := d.NewPlaylist(ctx, p); err != nil {
+						t.Fatal(err)
+					}
+				}
+				playlists, err := d.GetAllUserPlaylists(ctx, owner)
+				if err != nil || len(playlists) != 1 {
+					t.Fatalf("playlists of owner = %+v, %v", playlists, err)
+				}
+				playlist := playlists[0].ID
+				t.Cleanup(func() {
+					for _, user := range []string{owner, other} {
+						playlists, _ := d.GetAllUserPlaylists(ctx, user)
+						for _, p := range playlists {
+							d.DeletePlaylistByID(ctx, p.ID)
+						}
+					}
+				})
+
+				if err := tt.call(ctx, d, playlist); err != tt.err {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+
+				if theirs, err := d.GetAllUserPlaylists(ctx, other); err != nil || len(theirs) != 1 || theirs[0].Name != "theirs" {
+					t.Errorf("playlists of other user = %+v, %v", theirs, err)
+				}
+				p, err := d.GetPlaylistByID(ctx, playlist)
+				if tt.deleted {
+					if err != ErrNotFound {
+						t.Errorf("playlist is not deleted: %+v, %v", p, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.ID != playlist || p.OwnerID != owner || p.Name != "mine" {
+					t.Errorf("playlist = %+v", p)
+				}
+				var songs []string
+				for _, s := range p.Songs {
+					songs = append(songs, s.ID)
+				}
+				if len(songs) != len(tt.songs) {
+					t.Fatalf("songs = %v, want %v", songs, tt.songs)
+				}
+				for i := range songs {
+					if songs[i] != tt.songs[i] {
+						t.Errorf("songs = %v, want %v", songs, tt.songs)
+					}
+				}
+			})
+		}
+	})
+}
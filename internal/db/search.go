@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/search"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchCandidatesLimit - max number of matching songs selected from db for ranking in one search,
+// newest songs are selected first. Total of SearchSongs counts every match
+const SearchCandidatesLimit = 1000
+
+// searchOrder - order of candidates before ranking, newest first and then by id desc,
+// search.Rank is stable so songs of equal rank keep it
+var searchOrder = SongsQuery{SortBy: SongsSortUploaded, Desc: true}
+
+// SearchQuery - text query with page of ranked results
+type SearchQuery struct {
+	Query  string
+	Offset int
+	Limit  int
+}
+
+// searchSelector - selects songs where every query term is the beginning of a word of name, artist
+// or album, which is what search.Score accepts, so counting it gives the number of matches
+func searchSelector(terms []string) obj {
+	and := make([]obj, 0, len(terms))
+	for _, t := range terms {
+		re := primitive.Regex{Pattern: `(?:^|[^\p{L}\p{N}])` + regexp.QuoteMeta(t), Options: "i"}
+		and = append(and, obj{"$or": []obj{
+			{SongFieldName: re},
+			{SongFieldArtist: re},
+			{SongFieldAlbum: re},
+		}})
+	}
+	return obj{"$and": and}
+}
+
+// rankSongs - ranks raw song documents and returns requested page with total number of matches
+func rankSongs(q SearchQuery, raw []bson.Raw) (result []globalStructs.Song, total int, err error) {
+	docs := make([]search.Document, 0, len(raw))
+	for _, r := range raw {
+		var doc bson.M
+		if err = bson.Unmarshal(r, &doc); err != nil {
+			return nil, 0, err
+		}
+		name, _ := doc[SongFieldName].(string)
+		artist, _ := doc[SongFieldArtist].(string)
+		album, _ := doc[SongFieldAlbum].(string)
+		docs = append(docs, search.Document{Name: name, Artist: artist, Album: album})
+	}
+
+	ranked := search.Rank(q.Query, docs)
+	total = len(ranked)
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+	ranked = ranked[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(ranked) {
+		ranked = ranked[:q.Limit]
+	}
+
+	for _, r := range ranked {
+		var s globalStructs.Song
+		if err = bson.Unmarshal(raw[r.Index], &s); err != nil {
+			return nil, 0, err
+		}
+		result = append(result, s)
+	}
+	return
+}
+
+// SearchSongs - finds songs by name, artist and album, results are ranked by search.Rank.
+// Only SearchCandidatesLimit newest matches are ranked, total counts all of them
+func (d *DB) SearchSongs(ctx context.Context, q SearchQuery) (result []globalStructs.Song, total int, err error) {
+	terms := search.Terms(q.Query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	selector := searchSelector(terms)
+	count, err := d.SongsCollection.CountDocuments(ctx, selector)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetLimit(SearchCandidatesLimit).
+		SetSort(searchOrder.sort())
+	cur, err := d.SongsCollection.Find(ctx, selector, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var raw []bson.Raw
+	if err = cur.All(ctx, &raw); err != nil {
+		return nil, 0, err
+	}
+	result, total, err = rankSongs(q, raw)
+	if int(count) > total {
+		total = int(count)
+	}
+	return
+}
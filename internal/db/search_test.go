@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+)
+
+func TestSearchSongsOrdersTiesByUploadTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		q     SearchQuery
+		want  []string
+		total int
+	}{
+		{
+			name:  "better match first, equal rank newest first",
+			q:     SearchQuery{Query: "song"},
+			want:  []string{"best", "b", "c", "a"},
+			total: 4,
+		},
+		{
+			name:  "page",
+			q:     SearchQuery{Query: "song", Offset: 1, Limit: 2},
+			want:  []string{"b", "c"},
+			total: 4,
+		},
+		{
+			name: "nothing matches",
+			q:    SearchQuery{Query: "missing"},
+		},
+	}
+
+	eachDB(t, func(t *testing.T, d IDB) {
+		// upload time has millisecond precision, so songs are inserted apart
+		for _, s := range []globalStructs.Song{
+			{ID: "best", Name: "Song", Artist: "Song"},
+			{ID: "a", Name: "Song", Artist: "x"},
+			{ID: "c", Name: "Song", Artist: "x"},
+			{ID: "b", Name: "Song", Artist: "x"},
+		} {
+			insertSongs(t, d, "", s)
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				songs, total, err := d.SearchSongs(context.Background(), tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for _, s := range songs {
+					ids = append(ids, s.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.want) || total != tt.total {
+					t.Errorf("songs = %v of %d, want %v of %d", ids, total, tt.want, tt.total)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// DefaultSegmentsBatchSize - number of segments sent to mongo in one bulk insert
+const DefaultSegmentsBatchSize = 100
+
+// segmentDoc - stored segment, SongID links segment to the song it belongs to and
+// Created is set on insert and used as Last-Modified when serving it
+type segmentDoc struct {
+	globalStructs.SongData `bson:",inline"`
+	SongID                 string    `bson:"song_id"`
+	Created                time.Time `bson:"created"`
+}
+
+// SegmentError - error of a single segment in InsertSegment call, Index is position of the
+// segment in the call arguments or -1 when mongo did not report the position
+type SegmentError struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+// InsertSegmentsError - returned by InsertSegment when some of the segments were not inserted,
+// Inserted is number of segments written before insert stopped
+type InsertSegmentsError struct {
+	Inserted int
+	Failed   []SegmentError
+}
+
+func (e *InsertSegmentsError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, v := range e.Failed {
+		msgs = append(msgs, fmt.Sprintf("segment %d (%s): %s", v.Index, v.ID, v.Err))
+	}
+	return fmt.Sprintf("error inserting %d segments: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+// FailedIDs - ids of segments which were not inserted
+func (e *InsertSegmentsError) FailedIDs() []string {
+	ids := make([]string, 0, len(e.Failed))
+	for _, v := range e.Failed {
+		if v.ID != "" {
+			ids = append(ids, v.ID)
+		}
+	}
+	return ids
+}
+
+// InsertSegment - inserts segments of the song with unordered bulk writes of SegmentsBatchSize documents,
+// stops after the first batch with errors and returns *InsertSegmentsError describing every failed segment
+func (d *DB) InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) error {
+	size := d.SegmentsBatchSize
+	if size <= 0 {
+		size = DefaultSegmentsBatchSize
+	}
+
+	for start := 0; start < len(ts); start += size {
+		end := start + size
+		if end > len(ts) {
+			end = len(ts)
+		}
+
+		now := time.Now().UTC()
+		docs := make([]interface{}, 0, end-start)
+		for _, v := range ts[start:end] {
+			docs = append(docs, segmentDoc{SongData: v, SongID: songID, Created: now})
+		}
+
+		ctx, cancel := d.ctx(ctx)
+		_, err := d.SegmentsCollection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		d.log(ctx).Warn("error inserting segments batch", zap.Error(err),
+			zap.String("song_id", songID), zap.Int("start", start), zap.Int("size", len(docs)))
+
+		var berr mongo.BulkWriteException
+		if !errors.As(err, &berr) || len(berr.WriteErrors) == 0 {
+			return &InsertSegmentsError{
+				Inserted: start,
+				Failed:   []SegmentError{{Index: -1, Err: err}},
+			}
+		}
+
+		result := &InsertSegmentsError{Inserted: start + len(docs) - len(berr.WriteErrors)}
+		for _, e := range berr.WriteErrors {
+			serr := SegmentError{Index: -1, Err: dbError(e)}
+			if e.Index >= 0 && e.Index < len(docs) {
+				serr.Index = start + e.Index
+				serr.ID = ts[serr.Index].ID
+			}
+			result.Failed = append(result.Failed, serr)
+		}
+		return result
+	}
+	return nil
+}
+
+// GetSegmentSongID - id of the song segment was inserted for, empty for segments inserted
+// before it was stored
+func (d *DB) GetSegmentSongID(ctx context.Context, id string) (songID string, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	var doc segmentDoc
+	err = findOne(ctx, d.SegmentsCollection, obj{"_id": id}, &doc, options.FindOne().SetProjection(obj{"song_id": 1}))
+	return doc.SongID, err
+}
+
+// GetSegmentInfo - returns segment with time it was inserted, time is zero for segments
+// inserted before it was stored
+func (d *DB) GetSegmentInfo(ctx context.Context, id string) (result globalStructs.SongData, created time.Time, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	var doc segmentDoc
+	err = findOne(ctx, d.SegmentsCollection, obj{"_id": id}, &doc)
+	return doc.SongData, doc.Created, err
+}
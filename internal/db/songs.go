@@ -0,0 +1,194 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fields of songs collection used in queries
+const (
+	SongFieldName   = "name"
+	SongFieldArtist = "artist"
+	SongFieldAlbum  = "album"
+	// SongFieldUploaded - time song was inserted, it is not part of globalStructs.Song so it is
+	// only written by InsertSong. Songs inserted before it was stored do not have it
+	SongFieldUploaded = "uploaded"
+)
+
+// songDoc - stored song with time it was inserted
+type songDoc struct {
+	globalStructs.Song `bson:",inline"`
+	Uploaded           time.Time `bson:"uploaded"`
+}
+
+// values of SongsQuery.SortBy, SongsSortUploaded sorts by time of upload
+const (
+	SongsSortUploaded = "uploaded"
+	SongsSortName     = "name"
+	SongsSortArtist   = "artist"
+)
+
+// SongUpdate - fields of song to change, nil fields are kept
+type SongUpdate struct {
+	ID     string
+	Name   *string
+	Artist *string
+	Album  *string
+}
+
+// fields - new values of fields which are set
+func (u SongUpdate) fields() map[string]string {
+	f := map[string]string{}
+	if u.Name != nil {
+		f[SongFieldName] = *u.Name
+	}
+	if u.Artist != nil {
+		f[SongFieldArtist] = *u.Artist
+	}
+	if u.Album != nil {
+		f[SongFieldAlbum] = *u.Album
+	}
+	return f
+}
+
+// set - mongo $set of fields which are set, prefix is path of song in the document
+func (u SongUpdate) set(prefix string) obj {
+	set := obj{}
+	for field, v := range u.fields() {
+		set[prefix+field] = v
+	}
+	return set
+}
+
+// apply - sets fields of s which are set
+func (u SongUpdate) apply(s *globalStructs.Song) {
+	if u.Name != nil {
+		s.Name = *u.Name
+	}
+	if u.Artist != nil {
+		s.Artist = *u.Artist
+	}
+	if u.Album != nil {
+		s.Album = *u.Album
+	}
+}
+
+// DeleteSongResult - ids of segments and playlists affected by DeleteSong
+type DeleteSongResult struct {
+	Segments  []string
+	Playlists []string
+	// Partial - delete failed after some playlists or segments were changed, song itself is
+	// removed last so it is kept and delete can be retried
+	Partial bool
+}
+
+// SongsQuery - filter, sort and page of GetSongs, filters are case insensitive substring
+// matches and empty filter matches every song
+type SongsQuery struct {
+	Name   string
+	Artist string
+	Album  string
+
+	SortBy string
+	Desc   bool
+
+	Offset int
+	Limit  int
+}
+
+func (q SongsQuery) filters() map[string]string {
+	f := map[string]string{}
+	if q.Name != "" {
+		f[SongFieldName] = q.Name
+	}
+	if q.Artist != "" {
+		f[SongFieldArtist] = q.Artist
+	}
+	if q.Album != "" {
+		f[SongFieldAlbum] = q.Album
+	}
+	return f
+}
+
+// selector - mongo selector for query filters
+func (q SongsQuery) selector() obj {
+	sel := obj{}
+	for field, v := range q.filters() {
+		sel[field] = primitive.Regex{Pattern: regexp.QuoteMeta(v), Options: "i"}
+	}
+	return sel
+}
+
+// sortField - field songs are sorted by
+func (q SongsQuery) sortField() string {
+	switch q.SortBy {
+	case SongsSortName:
+		return SongFieldName
+	case SongsSortArtist:
+		return SongFieldArtist
+	}
+	return SongFieldUploaded
+}
+
+// sort - mongo sort document for query, songs with equal field are ordered by id so pages
+// neither overlap nor skip songs. Songs without upload time go first in ascending order
+func (q SongsQuery) sort() bson.D {
+	order := 1
+	if q.Desc {
+		order = -1
+	}
+	return bson.D{{Key: q.sortField(), Value: order}, {Key: "_id", Value: order}}
+}
+
+// less - checks that song document a goes before b in order of sort
+func (q SongsQuery) less(a, b bson.M) bool {
+	field := q.sortField()
+	if c := compareValues(a[field], b[field]); c != 0 {
+		return (c < 0) != q.Desc
+	}
+	c := compareValues(a["_id"], b["_id"])
+	return c != 0 && (c < 0) != q.Desc
+}
+
+// compareValues - compares string or datetime values of the same field, missing values are lowest like in mongo
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch av := a.(type) {
+	case string:
+		bv, _ := b.(string)
+		return strings.Compare(av, bv)
+	case primitive.DateTime:
+		bv, _ := b.(primitive.DateTime)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	}
+	return 0
+}
+
+// match - checks bson song document against query filters the same way selector does in mongo
+func (q SongsQuery) match(doc bson.M) bool {
+	for field, v := range q.filters() {
+		s, _ := doc[field].(string)
+		if !strings.Contains(strings.ToLower(s), strings.ToLower(v)) {
+			return false
+		}
+	}
+	return true
+}
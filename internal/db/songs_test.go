@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertSongs - inserts songs which are removed with playlists of owner after the test
+func insertSongs(t *testing.T, d IDB, owner string, songs ...globalStructs.Song) {
+	t.Helper()
+	ctx := context.Background()
+	for _, s := range songs {
+		if err := d.InsertSong(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, s := range songs {
+			d.DeleteSong(ctx, s.ID, nil, false)
+		}
+		playlists, _ := d.GetAllUserPlaylists(ctx, owner)
+		for _, p := range playlists {
+			d.DeletePlaylistByID(ctx, p.ID)
+		}
+	})
+}
+
+func TestUpdateSong(t *testing.T) {
+	name, artist, empty := "new name", "new artist", ""
+	song := globalStructs.Song{ID: "song", Name: "name", Artist: "artist", Album: "album"}
+	other := globalStructs.Song{ID: "other", Name: "other", Artist: "artist", Album: "album"}
+
+	tests := []struct {
+		name      string
+		update    SongUpdate
+		err       bool
+		want      globalStructs.Song
+		playlists int
+	}{
+		{
+			name:      "only sent fields change",
+			update:    SongUpdate{ID: "song", Name: &name},
+			want:      globalStructs.Song{ID: "song", Name: "new name", Artist: "artist", Album: "album"},
+			playlists: 2,
+		},
+		{
+			name:      "field is cleared",
+			update:    SongUpdate{ID: "song", Artist: &artist, Album: &empty},
+			want:      globalStructs.Song{ID: "song", Name: "name", Artist: "new artist"},
+			playlists: 2,
+		},
+		{
+			name:   "nothing to update",
+			update: SongUpdate{ID: "song"},
+			err:    true,
+			want:   song,
+		},
+		{
+			name:   "missing song",
+			update: SongUpdate{ID: "missing", Name: &name},
+			err:    true,
+			want:   song,
+		},
+	}
+
+	eachDB(t, func(t *testing.T, d IDB) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				insertSongs(t, d, "alice", song, other)
+				for _, p := range []globalStructs.Playlist{
+					{Name: "with song", OwnerID: "alice", Songs: []globalStructs.Song{other, song}},
+					{Name: "with song twice", OwnerID: "alice", Songs: []globalStructs.Song{song, song}},
+					{Name: "without song", OwnerID: "alice", Songs: []globalStructs.Song{other}},
+				} {
+					if err := d.NewPlaylist(ctx, p); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				playlists, err := d.UpdateSong(ctx, tt.update)
+				if (err != nil) != tt.err {
+					t.Fatalf("err = %v, want error %v", err, tt.err)
+				}
+				if playlists != tt.playlists {
+					t.Errorf("updated playlists = %d, want %d", playlists, tt.playlists)
+				}
+
+				if got, err := d.GetSongByID(ctx, "song"); err != nil || got != tt.want {
+					t.Errorf("song = %+v, %v, want %+v", got, err, tt.want)
+				}
+				if got, err := d.GetSongByID(ctx, "other"); err != nil || got != other {
+					t.Errorf("other song = %+v, %v", got, err)
+				}
+
+				short, err := d.GetAllUserPlaylists(ctx, "alice")
+				if err != nil || len(short) != 3 {
+					t.Fatalf("playlists = %+v, %v", short, err)
+				}
+				for _, s := range short {
+					p, err := d.GetPlaylistByID(ctx, s.ID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, v := range p.Songs {
+						if v.ID == other.ID && v != other {
+							t.Errorf("copy of other song in %q = %+v", p.Name, v)
+						}
+						if v.ID == "song" && v != tt.want {
+							t.Errorf("copy of song in %q = %+v, want %+v", p.Name, v, tt.want)
+						}
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestGetSongs(t *testing.T) {
+	tests := []struct {
+		name  string
+		q     SongsQuery
+		want  []string
+		total int
+	}{
+		{
+			name:  "equal names are ordered by id",
+			q:     SongsQuery{SortBy: SongsSortName},
+			want:  []string{"b", "a", "c", "d"},
+			total: 4,
+		},
+		{
+			name:  "desc reverses ties as well",
+			q:     SongsQuery{SortBy: SongsSortName, Desc: true},
+			want:  []string{"d", "c", "a", "b"},
+			total: 4,
+		},
+		{
+			name:  "filter and sort by artist",
+			q:     SongsQuery{SortBy: SongsSortArtist, Name: "SAME"},
+			want:  []string{"d", "c", "a"},
+			total: 3,
+		},
+		{
+			name:  "page",
+			q:     SongsQuery{SortBy: SongsSortName, Offset: 1, Limit: 2},
+			want:  []string{"a", "c"},
+			total: 4,
+		},
+		{
+			name:  "last page",
+			q:     SongsQuery{SortBy: SongsSortName, Offset: 3, Limit: 2},
+			want:  []string{"d"},
+			total: 4,
+		},
+		{
+			name:  "offset at the end",
+			q:     SongsQuery{SortBy: SongsSortName, Offset: 4, Limit: 2},
+			total: 4,
+		},
+		{
+			name:  "offset past the end",
+			q:     SongsQuery{SortBy: SongsSortName, Offset: 10},
+			total: 4,
+		},
+		{
+			name: "nothing matches",
+			q:    SongsQuery{Album: "missing"},
+		},
+	}
+
+	eachDB(t, func(t *testing.T, d IDB) {
+		insertSongs(t, d, "",
+			globalStructs.Song{ID: "c", Name: "Same", Artist: "x"},
+			globalStructs.Song{ID: "a", Name: "Same", Artist: "y"},
+			globalStructs.Song{ID: "b", Name: "Other", Artist: "x"},
+			globalStructs.Song{ID: "d", Name: "same", Album: "z"},
+		)
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				songs, total, err := d.GetSongs(context.Background(), tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for _, s := range songs {
+					ids = append(ids, s.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.want) || total != tt.total {
+					t.Errorf("songs = %v of %d, want %v of %d", ids, total, tt.want, tt.total)
+				}
+			})
+		}
+	})
+}
+
+func TestSongsQueryLessBreaksUploadTiesByID(t *testing.T) {
+	uploaded := primitive.NewDateTimeFromTime(time.Now())
+	a := bson.M{"_id": "a", SongFieldUploaded: uploaded}
+	b := bson.M{"_id": "b", SongFieldUploaded: uploaded}
+	legacy := bson.M{"_id": "0"}
+
+	tests := []struct {
+		desc bool
+		x, y bson.M
+		want bool
+	}{
+		{false, a, b, true},
+		{false, b, a, false},
+		{false, a, a, false},
+		{true, b, a, true},
+		{true, a, b, false},
+		{false, legacy, a, true},
+		{true, legacy, a, false},
+	}
+	for _, tt := range tests {
+		q := SongsQuery{SortBy: SongsSortUploaded, Desc: tt.desc}
+		if got := q.less(tt.x, tt.y); got != tt.want {
+			t.Errorf("desc %v: %v before %v = %v, want %v", tt.desc, tt.x["_id"], tt.y["_id"], got, tt.want)
+		}
+	}
+}
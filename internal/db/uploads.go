@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"github.com/u2takey/go-utils/rand"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UploadKeyM3H8 - key of m3h8 in UploadSession.Segments, ts are keyed by their index
+const UploadKeyM3H8 = "m3h8"
+
+// fields of upload sessions collection used in queries
+const (
+	UploadFieldSegments = "segments"
+	UploadFieldExpires  = "expires"
+)
+
+// UploadSession - resumable upload of a song. Segments are stored as they arrive and song is inserted
+// on commit, session which got no segment until Expires is removed with its segments
+type UploadSession struct {
+	ID      string             `bson:"_id"`
+	Owner   string             `bson:"owner"`
+	Song    globalStructs.Song `bson:"song"`
+	TsCount int                `bson:"ts_count"`
+	// Segments - ids of stored segments by UploadKeyM3H8 or index of ts
+	Segments map[string]string `bson:"segments"`
+	Created  time.Time         `bson:"created"`
+	Expires  time.Time         `bson:"expires"`
+}
+
+// SegmentIDs - ids of all stored segments of session
+func (s UploadSession) SegmentIDs() []string {
+	ids := make([]string, 0, len(s.Segments))
+	for _, id := range s.Segments {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// NewUploadSession - inserts session with new random id and returns the id
+func (d *DB) NewUploadSession(ctx context.Context, s UploadSession) (id string, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	for {
+		s.ID = rand.String(24)
+		_, err := d.UploadsCollection.InsertOne(ctx, s)
+		if !mongo.IsDuplicateKeyError(err) {
+			return s.ID, err
+		}
+	}
+}
+
+func (d *DB) GetUploadSession(ctx context.Context, id string) (s UploadSession, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	err = findOne(ctx, d.UploadsCollection, obj{"_id": id}, &s)
+	return
+}
+
+// SetUploadSegment - records stored segment under key and moves expiry of the session,
+// returns ErrNotFound if session does not exist or has already expired and ErrConflict if
+// another segment is already recorded under key
+func (d *DB) SetUploadSegment(ctx context.Context, id, key, segmentID string, expires time.Time) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	field := UploadFieldSegments + "." + key
+	err := updateOne(ctx, d.UploadsCollection,
+		obj{
+			"_id":              id,
+			UploadFieldExpires: obj{"$gt": time.Now().UTC()},
+			"$or":              []obj{{field: obj{"$exists": false}}, {field: segmentID}},
+		},
+		obj{"$set": obj{field: segmentID, UploadFieldExpires: expires}})
+	if err != ErrNotFound {
+		return err
+	}
+
+	// filter matched nothing, key is taken if the session is still there
+	var s UploadSession
+	if err := findOne(ctx, d.UploadsCollection, obj{"_id": id}, &s); err != nil {
+		return err
+	}
+	if stored, ok := s.Segments[key]; ok && stored != segmentID && s.Expires.After(time.Now()) {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (d *DB) DeleteUploadSession(ctx context.Context, id string) error {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	return deleteOne(ctx, d.UploadsCollection, obj{"_id": id})
+}
+
+// ExpiredUploadSessions - up to limit sessions which expired before now
+func (d *DB) ExpiredUploadSessions(ctx context.Context, now time.Time, limit int) (result []UploadSession, err error) {
+	ctx, cancel := d.ctx(ctx)
+	defer cancel()
+
+	cur, err := d.UploadsCollection.Find(ctx, obj{UploadFieldExpires: obj{"$lte": now}}, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	err = cur.All(ctx, &result)
+	return
+}
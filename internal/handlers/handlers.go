@@ -1,43 +1,117 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/hls"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
 	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/service"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
 	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
 	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 )
 
+// SegmentCacheControl - Cache-Control of segment responses
+const SegmentCacheControl = "public, max-age=31536000, immutable"
+
+// status - http status of failed service call by code of its error
+func status(err error) int {
+	return codeStatus(service.Code(err))
+}
+
+// codeStatus - http status of structs.ErrCode* value
+func codeStatus(code string) int {
+	switch code {
+	case structs.ErrCodeBadRequest:
+		return http.StatusBadRequest
+	case structs.ErrCodeValidation:
+		return http.StatusUnprocessableEntity
+	case structs.ErrCodeUnauthorized:
+		return http.StatusUnauthorized
+	case structs.ErrCodeNotFound:
+		return http.StatusNotFound
+	case structs.ErrCodeForbidden:
+		return http.StatusForbidden
+	case structs.ErrCodeConflict:
+		return http.StatusConflict
+	case structs.ErrCodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case structs.ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// bindError - envelope of request which could not be parsed or did not pass validation
+func bindError(err error) structs.ErrorResp {
+	if fields := validation.Fields(err); fields != nil {
+		return structs.ErrorResp{Error: "invalid request", Code: structs.ErrCodeValidation, Fields: fields}
+	}
+	return structs.ErrorResp{Error: "error binding req: " + err.Error(), Code: structs.ErrCodeBadRequest}
+}
+
+// bodyError - bindError of request which body is limited by http.MaxBytesReader to limit bytes,
+// body over the limit is too large
+func bodyError(err error, limit int64) structs.ErrorResp {
+	var merr *http.MaxBytesError
+	if errors.As(err, &merr) {
+		return structs.ErrorResp{Error: fmt.Sprintf("request body is larger than %d bytes", limit), Code: structs.ErrCodeTooLarge}
+	}
+	return bindError(err)
+}
+
 type Handlers struct {
 	s      service.IService
 	logger *zap.Logger
+	// maxUpload - max size of UploadSegments body in bytes
+	maxUpload int
+	// maxAddSegments - max size of AddSegments body in bytes
+	maxAddSegments int64
 }
 
-func NewHandlers(s service.IService, l *zap.Logger) Handlers {
+func NewHandlers(s service.IService, u config.Upload, l *zap.Logger) Handlers {
 	return Handlers{
-		s:      s,
-		logger: l,
+		s:              s,
+		logger:         l,
+		maxUpload:      u.MaxSize,
+		maxAddSegments: maxAddSegmentsSize,
 	}
 }
 
+// log - request logger of c, it carries request id
+func (h *Handlers) log(c *gin.Context) *zap.Logger {
+	return logging.FromContext(c.Request.Context(), h.logger)
+}
+
 func (h *Handlers) AddSegments(c *gin.Context) {
 	var req structs.AddSegmentsReq
 	var resp structs.AddSegmentsResp
-	if err := c.Bind(&req); err != nil {
-		data, _ := ioutil.ReadAll(c.Request.Body)
-		h.logger.Error("error binding req", zap.Error(err), zap.String("data", string(data)))
-		resp.Error = "error binding req"
-		c.JSON(http.StatusBadRequest, resp)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxAddSegments)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bodyError(err, h.maxAddSegments)
+		resp.FailedPart = structs.IngestPartValidation
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
+	// binding of uploads takes a while, the event splits it from inserts in the trace
+	trace.SpanFromContext(c.Request.Context()).AddEvent("request bound",
+		trace.WithAttributes(attribute.Int("ts.count", len(req.Ts))))
 
-	resp, err := h.s.NewSegments(req)
+	resp, err := h.s.NewSegments(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error NewSegments()", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error NewSegments()", zap.Error(err), zap.String("failed_part", resp.FailedPart))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -45,29 +119,176 @@ func (h *Handlers) AddSegments(c *gin.Context) {
 }
 
 func (h *Handlers) GetAllSongs(c *gin.Context) {
-	resp, err := h.s.GetAllSongs()
+	resp, err := h.s.GetAllSongs(c.Request.Context())
+	if err != nil {
+		h.log(c).Error("error getting all songs", zap.Error(err))
+		c.JSON(status(err), resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) GetSongs(c *gin.Context) {
+	var req structs.GetSongsReq
+	var resp structs.GetSongsResp
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.GetSongs(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error getting all songs", zap.Error(err))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error getting songs", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) Search(c *gin.Context) {
+	var req structs.SearchReq
+	var resp structs.SearchResp
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.Search(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error searching songs", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
 	c.JSON(http.StatusOK, resp)
 }
 
 func (h *Handlers) GetSegment(c *gin.Context) {
 	var req structs.GetSegmentReq
 	var resp structs.GetSegmentResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.GetSegment(req)
+	resp, err := h.s.GetSegment(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error getting segment", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error getting segment", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetHLSPlaylist - serves stored m3u8 playlist with segment uris pointing to GetHLSSegment
+func (h *Handlers) GetHLSPlaylist(c *gin.Context) {
+	req := structs.GetSegmentReq{ID: c.Param("id")}
+	if err := validation.Struct(req); err != nil {
+		c.String(http.StatusUnprocessableEntity, "invalid id")
+		return
+	}
+
+	resp, err := h.s.GetSegment(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error getting playlist", zap.Error(err), logging.Any("id", c.Param("id")))
+		c.String(status(err), resp.Error)
+		return
+	}
+
+	if !hls.IsPlaylist(resp.Segment.Data) {
+		c.String(http.StatusNotFound, "segment is not a playlist")
+		return
+	}
+
+	data := hls.RewritePlaylist(resp.Segment.Data, func(id string) string {
+		return "../segment/" + url.PathEscape(id)
+	})
+	c.Data(http.StatusOK, hls.PlaylistContentType, data)
+}
+
+// GetHLSSegment - serves stored ts segment
+func (h *Handlers) GetHLSSegment(c *gin.Context) {
+	h.serveSegment(c, hls.SegmentContentType)
+}
+
+// GetRawSegment - serves stored segment as is, without json wrapping
+func (h *Handlers) GetRawSegment(c *gin.Context) {
+	h.serveSegment(c, "")
+}
+
+// serveSegment - writes segment with Range, ETag and Last-Modified support, segments never change
+// once inserted so they are cacheable forever. Empty contentType is detected from segment data
+func (h *Handlers) serveSegment(c *gin.Context, contentType string) {
+	req := structs.GetSegmentReq{ID: c.Param("id")}
+	if err := validation.Struct(req); err != nil {
+		c.String(http.StatusUnprocessableEntity, "invalid id")
+		return
+	}
+
+	resp, err := h.s.GetSegment(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error getting segment", zap.Error(err), logging.Any("id", c.Param("id")))
+		c.String(status(err), resp.Error)
+		return
+	}
+
+	data := resp.Segment.Data
+	if contentType == "" {
+		contentType = "application/octet-stream"
+		if hls.IsPlaylist(data) {
+			contentType = hls.PlaylistContentType
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	c.Header("Cache-Control", SegmentCacheControl)
+	http.ServeContent(c.Writer, c.Request, "", resp.Modified, bytes.NewReader(data))
+}
+
+func (h *Handlers) UpdateSong(c *gin.Context) {
+	var req structs.UpdateSongReq
+	var resp structs.UpdateSongResp
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.UpdateSong(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error updating song", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) DeleteSong(c *gin.Context) {
+	var req structs.DeleteSongReq
+	var resp structs.DeleteSongResp
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.DeleteSong(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error deleting song", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -77,17 +298,17 @@ func (h *Handlers) GetSegment(c *gin.Context) {
 func (h *Handlers) NewUser(c *gin.Context) {
 	var req globalStructs.User
 	var resp structs.NewUserResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.NewUser(req)
+	resp, err := h.s.NewUser(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error getting segment", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error creating new user", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -97,17 +318,17 @@ func (h *Handlers) NewUser(c *gin.Context) {
 func (h *Handlers) GetUser(c *gin.Context) {
 	var req structs.GetUserReq
 	var resp structs.GetUserResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.GetUser(req)
+	resp, err := h.s.GetUser(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error getting segment", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error getting user", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -117,17 +338,17 @@ func (h *Handlers) GetUser(c *gin.Context) {
 func (h *Handlers) NewPlaylist(c *gin.Context) {
 	var req structs.NewPlaylistReq
 	var resp structs.NewPlaylistResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.NewPlaylist(req)
+	resp, err := h.s.NewPlaylist(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error creating new playlist", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error creating new playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -137,17 +358,17 @@ func (h *Handlers) NewPlaylist(c *gin.Context) {
 func (h *Handlers) DeletePlaylist(c *gin.Context) {
 	var req structs.DeleteUserPlaylistReq
 	var resp structs.DeleteUserPlaylistResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.DeleteUserPlaylist(req)
+	resp, err := h.s.DeleteUserPlaylist(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error creating new playlist", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error deleting playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -157,17 +378,17 @@ func (h *Handlers) DeletePlaylist(c *gin.Context) {
 func (h *Handlers) GetUserPlaylists(c *gin.Context) {
 	var req structs.GetUserAllPlaylistsReq
 	var resp structs.GetUserAllPlaylistsResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.GetUserPlaylists(req)
+	resp, err := h.s.GetUserPlaylists(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error getting user laylists", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error getting user playlists", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -177,17 +398,17 @@ func (h *Handlers) GetUserPlaylists(c *gin.Context) {
 func (h *Handlers) GetUserPlaylist(c *gin.Context) {
 	var req structs.GetPlaylistReq
 	var resp structs.GetPlaylistResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.GetUserPlaylist(req)
+	resp, err := h.s.GetUserPlaylist(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error getting user playlist playlist", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error getting user playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -197,17 +418,17 @@ func (h *Handlers) GetUserPlaylist(c *gin.Context) {
 func (h *Handlers) AddSongToUserPlaylist(c *gin.Context) {
 	var req structs.AddSongToUserPlaylistReq
 	var resp structs.AddSongToUserPlaylistResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
 		return
 	}
 
-	resp, err := h.s.AddSongToUserPlaylist(req)
+	resp, err := h.s.AddSongToUserPlaylist(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error adding new song to playlist", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error adding new song to playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
@@ -217,19 +438,57 @@ func (h *Handlers) AddSongToUserPlaylist(c *gin.Context) {
 func (h *Handlers) RemoveSongFromUserPlaylist(c *gin.Context) {
 	var req structs.RemoveSongFromUserPlaylistReq
 	var resp structs.RemoveSongFromUserPlaylistResp
-	if err := c.Bind(&req); err != nil {
-		h.logger.Error("error binding req", zap.Error(err))
-		resp.Error = err.Error()
-		c.JSON(http.StatusBadRequest, resp)
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.RemoveSongFromUserPlaylist(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error removing song from user playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
-	resp, err := h.s.RemoveSongFromUserPlaylist(req)
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) SetUserRoles(c *gin.Context) {
+	var req structs.SetUserRolesReq
+	var resp structs.SetUserRolesResp
+	if err := c.ShouldBind(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.SetUserRoles(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Error("error removing song from user playlist", zap.Error(err), zap.Any("req", req))
-		c.JSON(http.StatusBadRequest, resp)
+		h.log(c).Error("error setting user roles", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
 		return
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// Healthz - liveness probe, process is up and serves http
+func (h *Handlers) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, structs.HealthResp{Status: structs.HealthStatusOK})
+}
+
+// Readyz - readiness probe, service can reach db
+func (h *Handlers) Readyz(c *gin.Context) {
+	if err := h.s.Ready(c.Request.Context()); err != nil {
+		h.log(c).Error("service is not ready", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, structs.HealthResp{
+			ErrorResp: structs.ErrorResp{Error: err.Error(), Code: structs.ErrCodeInternal},
+			Status:    structs.HealthStatusUnavailable,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, structs.HealthResp{Status: structs.HealthStatusOK})
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/hls"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/openapi"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/policy"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/service"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.uber.org/zap"
+)
+
+// newTestRouter - router with every route over a MemoryDB backed service, api is not authenticated.
+// opts change handlers before routes are registered
+func newTestRouter(t *testing.T, upload config.Upload, opts ...func(h *Handlers)) (*gin.Engine, db.IDB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	validation.Register()
+	if upload.SessionTTL == 0 {
+		upload.SessionTTL = time.Hour
+	}
+
+	mem := db.NewMemoryDB(zap.NewNop())
+	h := NewHandlers(service.NewService(mem, policy.New(nil), upload, zap.NewNop()), upload, zap.NewNop())
+	for _, opt := range opts {
+		opt(&h)
+	}
+	r := gin.New()
+	Register(r, &h, openapi.New(openapi.Info{}, Operations()), http.NotFoundHandler())
+	return r, mem
+}
+
+func TestAddSegments(t *testing.T) {
+	segment := func(id string) globalStructs.SongData {
+		return globalStructs.SongData{ID: id, Data: []byte(id)}
+	}
+	request := func(f func(req *structs.AddSegmentsReq)) structs.AddSegmentsReq {
+		req := structs.AddSegmentsReq{
+			SongData: globalStructs.Song{ID: "song", Name: "name"},
+			M3H8:     segment("m3h8"),
+			Ts:       []globalStructs.SongData{segment("ts0"), segment("ts1"), segment("ts2")},
+		}
+		if f != nil {
+			f(&req)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name string
+		req  structs.AddSegmentsReq
+		// maxBody - limit of request body, default when 0
+		maxBody int64
+		status  int
+		code    string
+		fields  []structs.FieldError
+	}{
+		{
+			name:   "ok",
+			req:    request(nil),
+			status: http.StatusOK,
+		},
+		{
+			name:   "invalid ts id",
+			req:    request(func(req *structs.AddSegmentsReq) { req.Ts[2].ID = "ts 2" }),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "ts[2].id", Rule: "id"}},
+		},
+		{
+			name: "too long ts id",
+			req: request(func(req *structs.AddSegmentsReq) {
+				req.Ts[1].ID = string(bytes.Repeat([]byte("a"), validation.MaxIDLength+1))
+			}),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "ts[1].id", Rule: "id"}},
+		},
+		{
+			name:   "missing ts id",
+			req:    request(func(req *structs.AddSegmentsReq) { req.Ts[0].ID = "" }),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "ts[0].id", Rule: "required"}},
+		},
+		{
+			name:   "invalid song id",
+			req:    request(func(req *structs.AddSegmentsReq) { req.SongData.ID = "song/1" }),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "song_data.id", Rule: "id"}},
+		},
+		{
+			name:   "empty m3h8",
+			req:    request(func(req *structs.AddSegmentsReq) { req.M3H8.Data = nil }),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "m3h8.data", Rule: "required"}},
+		},
+		{
+			name:   "oversized ts",
+			req:    request(func(req *structs.AddSegmentsReq) { req.Ts[1].Data = make([]byte, validation.MaxSegmentSize+1) }),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "ts[1].data", Rule: "max", Param: strconv.Itoa(validation.MaxSegmentSize)}},
+		},
+		{
+			name:   "no ts",
+			req:    request(func(req *structs.AddSegmentsReq) { req.Ts = nil }),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "ts", Rule: "required"}},
+		},
+		{
+			name: "too many ts",
+			req: request(func(req *structs.AddSegmentsReq) {
+				req.Ts = nil
+				for i := 0; i <= MaxUploadTs; i++ {
+					req.Ts = append(req.Ts, segment("ts"+strconv.Itoa(i)))
+				}
+			}),
+			status: http.StatusUnprocessableEntity,
+			code:   structs.ErrCodeValidation,
+			fields: []structs.FieldError{{Field: "ts", Rule: "max", Param: strconv.Itoa(MaxUploadTs)}},
+		},
+		{
+			name:    "body over limit",
+			req:     request(nil),
+			maxBody: 64,
+			status:  http.StatusRequestEntityTooLarge,
+			code:    structs.ErrCodeTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mem := newTestRouter(t, config.Upload{}, func(h *Handlers) {
+				if tt.maxBody != 0 {
+					h.maxAddSegments = tt.maxBody
+				}
+			})
+
+			body, err := json.Marshal(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/addSegment", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var resp structs.AddSegmentsResp
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || resp.Code != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", w.Code, resp.Code, tt.status, tt.code, resp.Error)
+			}
+			if tt.code != "" && resp.FailedPart != structs.IngestPartValidation {
+				t.Errorf("failed part = %q, want %q", resp.FailedPart, structs.IngestPartValidation)
+			}
+			if len(resp.Fields) != len(tt.fields) {
+				t.Fatalf("fields = %+v, want %+v", resp.Fields, tt.fields)
+			}
+			for i, f := range resp.Fields {
+				want := tt.fields[i]
+				if f.Field != want.Field || f.Rule != want.Rule || f.Param != want.Param {
+					t.Errorf("field %d = %+v, want %+v", i, f, want)
+				}
+			}
+
+			_, err = mem.GetSongByID(context.Background(), "song")
+			if stored := err == nil; stored != (tt.status == http.StatusOK) {
+				t.Errorf("song stored = %v", stored)
+			}
+		})
+	}
+}
+
+func TestGetHLSPlaylist(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		status int
+		body   string
+	}{
+		{
+			name:   "segment uris point to hls segment route",
+			id:     "m3h8",
+			status: http.StatusOK,
+			body:   "#EXTM3U\n#EXTINF:10,\n../segment/ts0\n#EXTINF:10,\n../segment/ts1\n",
+		},
+		{name: "segment is not a playlist", id: "ts0", status: http.StatusNotFound},
+		{name: "missing playlist", id: "missing", status: http.StatusNotFound},
+		{name: "invalid id", id: "m3h8!", status: http.StatusUnprocessableEntity},
+	}
+
+	r, mem := newTestRouter(t, config.Upload{})
+	err := mem.InsertSegment(context.Background(), "song",
+		globalStructs.SongData{ID: "m3h8", Data: []byte("#EXTM3U\n#EXTINF:10,\nts0\n#EXTINF:10,\n/old/segments/ts1?token=1\n")},
+		globalStructs.SongData{ID: "ts0", Data: []byte("ts0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/hls/playlist/"+tt.id, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != hls.PlaylistContentType {
+				t.Errorf("content type = %q", got)
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestHLSRoutesArePublic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mem := db.NewMemoryDB(zap.NewNop())
+	err := mem.InsertSegment(context.Background(), "song",
+		globalStructs.SongData{ID: "m3h8", Data: []byte("#EXTM3U\n#EXTINF:10,\nts0\n")},
+		globalStructs.SongData{ID: "ts0", Data: []byte("ts0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlers(service.NewService(mem, policy.New(nil), config.Upload{}, zap.NewNop()), config.Upload{}, zap.NewNop())
+	r := gin.New()
+	Register(r, &h, openapi.New(openapi.Info{}, Operations()), http.NotFoundHandler(), func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/api/v1/hls/playlist/m3h8", http.StatusOK},
+		{"/api/v1/hls/segment/ts0", http.StatusOK},
+		{"/api/v1/segment/ts0", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s status = %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+}
+
+func TestServeSegment(t *testing.T) {
+	data := []byte("0123456789")
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	r, mem := newTestRouter(t, config.Upload{})
+	ctx := context.Background()
+	if err := mem.InsertSegment(ctx, "song", globalStructs.SongData{ID: "ts0", Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	_, created, err := mem.GetSegmentInfo(ctx, "ts0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := created.UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name         string
+		path         string
+		header       http.Header
+		status       int
+		body         string
+		contentType  string
+		contentRange string
+	}{
+		{
+			name:        "whole segment",
+			path:        "/api/v1/segment/ts0",
+			status:      http.StatusOK,
+			body:        "0123456789",
+			contentType: "application/octet-stream",
+		},
+		{
+			name:        "hls segment",
+			path:        "/api/v1/hls/segment/ts0",
+			status:      http.StatusOK,
+			body:        "0123456789",
+			contentType: hls.SegmentContentType,
+		},
+		{
+			name:         "range",
+			path:         "/api/v1/segment/ts0",
+			header:       http.Header{"Range": {"bytes=2-5"}},
+			status:       http.StatusPartialContent,
+			body:         "2345",
+			contentType:  "application/octet-stream",
+			contentRange: "bytes 2-5/10",
+		},
+		{
+			name:         "suffix range",
+			path:         "/api/v1/hls/segment/ts0",
+			header:       http.Header{"Range": {"bytes=-3"}},
+			status:       http.StatusPartialContent,
+			body:         "789",
+			contentType:  hls.SegmentContentType,
+			contentRange: "bytes 7-9/10",
+		},
+		{
+			name:         "range past the end",
+			path:         "/api/v1/segment/ts0",
+			header:       http.Header{"Range": {"bytes=20-30"}},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */10",
+		},
+		{
+			name:   "matching etag",
+			path:   "/api/v1/segment/ts0",
+			header: http.Header{"If-None-Match": {etag}},
+			status: http.StatusNotModified,
+		},
+		{
+			name:        "other etag",
+			path:        "/api/v1/segment/ts0",
+			header:      http.Header{"If-None-Match": {`"other"`}},
+			status:      http.StatusOK,
+			body:        "0123456789",
+			contentType: "application/octet-stream",
+		},
+		{
+			name:   "not modified since",
+			path:   "/api/v1/hls/segment/ts0",
+			header: http.Header{"If-Modified-Since": {modified}},
+			status: http.StatusNotModified,
+		},
+		{
+			name:        "modified since",
+			path:        "/api/v1/segment/ts0",
+			header:      http.Header{"If-Modified-Since": {created.Add(-time.Hour).UTC().Format(http.TimeFormat)}},
+			status:      http.StatusOK,
+			body:        "0123456789",
+			contentType: "application/octet-stream",
+		},
+		{
+			name:        "range with stale if-range",
+			path:        "/api/v1/segment/ts0",
+			header:      http.Header{"Range": {"bytes=2-5"}, "If-Range": {`"other"`}},
+			status:      http.StatusOK,
+			body:        "0123456789",
+			contentType: "application/octet-stream",
+		},
+		{name: "missing segment", path: "/api/v1/segment/missing", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("content range = %q, want %q", got, tt.contentRange)
+			}
+			if w.Code == http.StatusNotFound || w.Code == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+			if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("content type = %q, want %q", w.Header().Get("Content-Type"), tt.contentType)
+			}
+			headers := map[string]string{"ETag": etag, "Last-Modified": modified, "Cache-Control": SegmentCacheControl}
+			if w.Code == http.StatusNotModified {
+				// net/http leaves out Last-Modified of 304 when ETag is set
+				delete(headers, "Last-Modified")
+			}
+			for k, want := range headers {
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+	}{
+		{structs.ErrCodeBadRequest, http.StatusBadRequest},
+		{structs.ErrCodeValidation, http.StatusUnprocessableEntity},
+		{structs.ErrCodeUnauthorized, http.StatusUnauthorized},
+		{structs.ErrCodeNotFound, http.StatusNotFound},
+		{structs.ErrCodeForbidden, http.StatusForbidden},
+		{structs.ErrCodeConflict, http.StatusConflict},
+		{structs.ErrCodeTooLarge, http.StatusRequestEntityTooLarge},
+		{structs.ErrCodeTimeout, http.StatusGatewayTimeout},
+		{structs.ErrCodeInternal, http.StatusInternalServerError},
+		{"unknown", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := codeStatus(tt.code); got != tt.status {
+			t.Errorf("codeStatus(%q) = %d, want %d", tt.code, got, tt.status)
+		}
+		err := fmt.Errorf("call: %w", service.NewError(tt.code, "failed"))
+		if got := status(err); got != tt.status {
+			t.Errorf("status of %q error = %d, want %d", tt.code, got, tt.status)
+		}
+	}
+	if got := status(errors.New("untyped")); got != http.StatusInternalServerError {
+		t.Errorf("status of untyped error = %d, want %d", got, http.StatusInternalServerError)
+	}
+}
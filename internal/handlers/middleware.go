@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/auth"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/metrics"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// PrincipalKey - gin context key of auth.Principal of authenticated request
+const PrincipalKey = "principal"
+
+// RequestIDHeader - header with correlation id of request, it is taken from the caller
+// when valid and returned in response
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey - gin context key of request id
+const RequestIDKey = "request_id"
+
+// RequestID - sets request id from RequestIDHeader or a new random one, and puts child of logger
+// with the id into request context, where handlers, Service and db take it from. Logger also gets
+// trace id when request is traced, so it goes after Tracing
+func RequestID(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || !validation.IsID(id) {
+			id = newRequestID()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		l := logger.With(zap.String("request_id", id))
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			l = l.With(zap.String("trace_id", sc.TraceID().String()))
+		}
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), l))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// AccessLog - logs every finished request with logger of request context, so it goes after RequestID
+func AccessLog(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		l := logging.FromContext(c.Request.Context(), logger)
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("route", c.FullPath()),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", c.Writer.Status()),
+			zap.Int("size", c.Writer.Size()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+		}
+		if p, ok := auth.FromContext(c.Request.Context()); ok {
+			fields = append(fields, zap.String("user_id", p.UserID), zap.String("service", p.Service))
+		}
+
+		switch {
+		case c.Writer.Status() >= http.StatusInternalServerError:
+			l.Error("request", fields...)
+		case c.Writer.Status() >= http.StatusBadRequest:
+			l.Warn("request", fields...)
+		default:
+			l.Info("request", fields...)
+		}
+	}
+}
+
+// UnmatchedRoute - route label of requests which matched no route, keeps label cardinality bounded
+const UnmatchedRoute = "unmatched"
+
+// Metrics - counts requests and observes their latency by route path, not by url
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		completed := false
+		// recorded in defer so requests which panic are counted too, gin.Recovery answers
+		// them with 500 after the panic leaves this middleware
+		defer func() {
+			status := c.Writer.Status()
+			if !completed {
+				status = http.StatusInternalServerError
+			}
+			route := c.FullPath()
+			if route == "" {
+				route = UnmatchedRoute
+			}
+			m.HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(status)).Inc()
+			m.HTTPDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+		}()
+
+		c.Next()
+		completed = true
+	}
+}
+
+// Tracing - starts server span of request named by route path, continuing trace of the caller
+// from w3c trace context headers. Spans of 5xx responses are marked failed
+func Tracing(t trace.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = UnmatchedRoute
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := t.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// Timeout - sets deadline of request context by route path, routes with zero timeout have no deadline.
+// Request context is also cancelled by net/http when client disconnects, so db calls made with it stop
+// in both cases
+func Timeout(timeout func(path string) time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := timeout(c.FullPath())
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// Auth - authenticates bearer token of request and puts auth.Principal into request context,
+// where Service takes it from, and into gin context. Requests without valid token get 401
+func Auth(a auth.Authenticator, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		if header == "" || token == header {
+			unauthorized(c, "bearer token is required")
+			return
+		}
+
+		p, err := a.Authenticate(c.Request, token)
+		if err != nil {
+			logging.FromContext(c.Request.Context(), logger).Info("error authenticating request", zap.Error(err), zap.String("path", c.FullPath()))
+			unauthorized(c, "invalid token")
+			return
+		}
+
+		c.Set(PrincipalKey, p)
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, structs.ErrorResp{Error: msg, Code: structs.ErrCodeUnauthorized})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/metrics"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestMetricsCountsPanics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := metrics.New(prometheus.NewRegistry())
+	r := gin.New()
+	r.Use(gin.Recovery(), Metrics(m))
+	r.GET("/panic/:id", func(c *gin.Context) { panic("boom") })
+	r.GET("/ok/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/panic/1", "/ok/1", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	tests := []struct {
+		route, status string
+	}{
+		{"/panic/:id", "500"},
+		{"/ok/:id", "204"},
+		{UnmatchedRoute, "404"},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.HTTPRequests.WithLabelValues(http.MethodGet, tt.route, tt.status)); got != 1 {
+			t.Errorf("requests of %s with %s = %v, want 1", tt.route, tt.status, got)
+		}
+	}
+	if got := testutil.CollectAndCount(m.HTTPDuration); got != 3 {
+		t.Errorf("observed %d routes, want 3", got)
+	}
+}
+
+func TestTracingNamesSpanByRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(context.Background())
+
+	r := gin.New()
+	r.Use(Tracing(tp.Tracer("test")))
+	r.GET("/api/v1/segment/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/api/v1/fail/:id", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	tests := []struct {
+		path   string
+		name   string
+		failed bool
+	}{
+		{"/api/v1/segment/abc", "GET /api/v1/segment/:id", false},
+		{"/api/v1/fail/abc", "GET /api/v1/fail/:id", true},
+		{"/missing/abc", "GET " + UnmatchedRoute, false},
+	}
+	for i, tt := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		spans := recorder.Ended()
+		if len(spans) != i+1 {
+			t.Fatalf("%s: %d spans ended, want %d", tt.path, len(spans), i+1)
+		}
+		span := spans[i]
+		if span.Name() != tt.name {
+			t.Errorf("%s: span name = %q, want %q", tt.path, span.Name(), tt.name)
+		}
+		if (span.Status().Code == codes.Error) != tt.failed {
+			t.Errorf("%s: span status = %v", tt.path, span.Status())
+		}
+		for _, kv := range span.Attributes() {
+			if kv.Key == semconv.URLPathKey && kv.Value.AsString() != tt.path {
+				t.Errorf("%s: url.path = %q", tt.path, kv.Value.AsString())
+			}
+		}
+	}
+}
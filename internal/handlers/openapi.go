@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/hls"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/openapi"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+)
+
+// Operations - documentation of every route added by Register, TestOperations fails
+// for routes missing here
+func Operations() []openapi.Operation {
+	return []openapi.Operation{
+		{Method: http.MethodGet, Path: "/healthz", Summary: "liveness probe", Tag: "health", Response: structs.HealthResp{}, Public: true},
+		{Method: http.MethodGet, Path: "/readyz", Summary: "readiness probe, pings db", Tag: "health", Response: structs.HealthResp{}, Public: true},
+		{Method: http.MethodGet, Path: "/openapi.json", Summary: "this document", Tag: "health", ContentType: "application/json", Public: true},
+		{Method: http.MethodGet, Path: "/metrics", Summary: "prometheus metrics", Tag: "health", ContentType: MetricsContentType, Public: true},
+
+		// v1 songs
+		{Method: http.MethodPost, Path: "/api/v1/addSegment", Summary: "upload song with its m3h8 and ts segments, uploader only", Tag: "songs",
+			Body: structs.AddSegmentsReq{}, Response: structs.AddSegmentsResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/upload", Summary: "stream song as multipart song_data, m3h8 and ts parts, uploader only", Tag: "songs",
+			Body: uploadForm{}, BodyContentType: "multipart/form-data", Response: structs.AddSegmentsResp{}},
+		{Method: http.MethodGet, Path: "/api/v1/allsongs", Summary: "first 1000 songs", Tag: "songs", Response: structs.GetAllSongsResp{}},
+		{Method: http.MethodGet, Path: "/api/v1/songs", Summary: "filtered and sorted page of songs", Tag: "songs",
+			Query: structs.GetSongsReq{}, Response: structs.GetSongsResp{}},
+		{Method: http.MethodGet, Path: "/api/v1/search", Summary: "songs ranked by relevance to query", Tag: "songs",
+			Query: structs.SearchReq{}, Response: structs.SearchResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/getsegment", Summary: "segment wrapped in json", Tag: "songs",
+			Body: structs.GetSegmentReq{}, Response: structs.GetSegmentResp{}},
+		{Method: http.MethodGet, Path: "/api/v1/segment/:id", Summary: "raw segment, supports Range and conditional requests", Tag: "songs",
+			ContentType: "application/octet-stream"},
+		{Method: http.MethodGet, Path: "/api/v1/hls/playlist/:id", Summary: "m3u8 playlist with segment uris of this api, public", Tag: "songs",
+			ContentType: hls.PlaylistContentType, Public: true},
+		{Method: http.MethodGet, Path: "/api/v1/hls/segment/:id", Summary: "ts segment, supports Range and conditional requests, public", Tag: "songs",
+			ContentType: hls.SegmentContentType, Public: true},
+		{Method: http.MethodPost, Path: "/api/v1/update_song", Summary: "update song metadata in songs and playlists, uploader only", Tag: "songs",
+			Body: structs.UpdateSongReq{}, Response: structs.UpdateSongResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/delete_song", Summary: "delete song with segments and remove it from playlists, uploader only", Tag: "songs",
+			Body: structs.DeleteSongReq{}, Response: structs.DeleteSongResp{}},
+
+		// v1 users
+		{Method: http.MethodPost, Path: "/api/v1/new_user", Summary: "create user, users create only themselves, admins create any", Tag: "users",
+			Body: globalStructs.User{}, Response: structs.NewUserResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/get_user", Summary: "get user by id, users get only themselves, admins get any", Tag: "users",
+			Body: structs.GetUserReq{}, Response: structs.GetUserResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/set_user_roles", Summary: "replace roles of user, admin only", Tag: "users",
+			Body: structs.SetUserRolesReq{}, Response: structs.SetUserRolesResp{}},
+
+		// v1 playlists
+		{Method: http.MethodPost, Path: "/api/v1/new_playlist", Summary: "create playlist of user", Tag: "playlists",
+			Body: structs.NewPlaylistReq{}, Response: structs.NewPlaylistResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/delete_playlist", Summary: "delete playlist of user, admins delete any", Tag: "playlists",
+			Body: structs.DeleteUserPlaylistReq{}, Response: structs.DeleteUserPlaylistResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/user_playlists", Summary: "playlists of user, users get only their own, admins get any", Tag: "playlists",
+			Body: structs.GetUserAllPlaylistsReq{}, Response: structs.GetUserAllPlaylistsResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/get_playlist", Summary: "get playlist by id, users get their own and shared ones, admins get any", Tag: "playlists",
+			Body: structs.GetPlaylistReq{}, Response: structs.GetPlaylistResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/add_song_playlist", Summary: "add song to playlist of user, admins change any", Tag: "playlists",
+			Body: structs.AddSongToUserPlaylistReq{}, Response: structs.AddSongToUserPlaylistResp{}},
+		{Method: http.MethodPost, Path: "/api/v1/remove_song_playlist", Summary: "remove song from playlist of user, admins change any", Tag: "playlists",
+			Body: structs.RemoveSongFromUserPlaylistReq{}, Response: structs.RemoveSongFromUserPlaylistResp{}},
+
+		// v2
+		{Method: http.MethodGet, Path: "/api/v2/users/:id", Summary: "get user by id, users get only themselves, admins get any", Tag: "users", Response: structs.GetUserResp{}},
+		{Method: http.MethodGet, Path: "/api/v2/users/:id/playlists", Summary: "playlists of user, users get only their own, admins get any", Tag: "playlists",
+			Response: structs.GetUserAllPlaylistsResp{}},
+		{Method: http.MethodDelete, Path: "/api/v2/playlists/:id", Summary: "delete playlist of user, admins delete any", Tag: "playlists",
+			Query: userQuery{}, Response: structs.DeleteUserPlaylistResp{}},
+		{Method: http.MethodPut, Path: "/api/v2/playlists/:id/songs/:songId", Summary: "add song to playlist of user, admins change any", Tag: "playlists",
+			Query: userQuery{}, Response: structs.AddSongToUserPlaylistResp{}},
+
+		// v2 resumable uploads
+		{Method: http.MethodPost, Path: "/api/v2/uploads", Summary: "start resumable upload of song, uploader only", Tag: "uploads",
+			Body: structs.CreateUploadReq{}, Response: structs.UploadSessionResp{}},
+		{Method: http.MethodGet, Path: "/api/v2/uploads/:id", Summary: "progress of upload, missing ts are listed", Tag: "uploads",
+			Query: userQuery{}, Response: structs.UploadSessionResp{}},
+		{Method: http.MethodPut, Path: "/api/v2/uploads/:id/m3h8/:segmentId", Summary: "upload m3h8 of song, retry with the same segment id is a no-op", Tag: "uploads",
+			Query: userQuery{}, Body: []byte{}, BodyContentType: "application/octet-stream", Response: structs.UploadSessionResp{}},
+		{Method: http.MethodPut, Path: "/api/v2/uploads/:id/ts/:n/:segmentId", Summary: "upload ts number n of song, retry with the same segment id is a no-op", Tag: "uploads",
+			Query: userQuery{}, Body: []byte{}, BodyContentType: "application/octet-stream", Response: structs.UploadSessionResp{}},
+		{Method: http.MethodPost, Path: "/api/v2/uploads/:id/commit", Summary: "insert song when all segments are uploaded", Tag: "uploads",
+			Query: userQuery{}, Response: structs.CommitUploadResp{}},
+		{Method: http.MethodDelete, Path: "/api/v2/uploads/:id", Summary: "abort upload and remove its segments", Tag: "uploads",
+			Query: userQuery{}, Response: structs.AbortUploadResp{}},
+	}
+}
+
+// userQuery - query of v2 playlist and upload routes, user_id is only used by services and when auth is disabled
+type userQuery struct {
+	UserID string `form:"user_id" binding:"omitempty,id"`
+}
+
+// MetricsContentType - content type of prometheus text format
+const MetricsContentType = "text/plain; version=0.0.4"
+
+// OpenAPI - serves document, it is built once at start
+func OpenAPI(doc *openapi.Document) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	}
+}
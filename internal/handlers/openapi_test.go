@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/openapi"
+	"go.uber.org/zap"
+)
+
+func TestOperations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	doc := openapi.New(openapi.Info{Title: "spotify-db", Version: "2"}, Operations())
+	h := NewHandlers(nil, config.Upload{}, zap.NewNop())
+	r := gin.New()
+	Register(r, &h, doc, http.NotFoundHandler())
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		registered[route.Method+" "+route.Path] = true
+		if !doc.Has(route.Method, route.Path) {
+			t.Errorf("route %s %s is not documented in Operations", route.Method, route.Path)
+		}
+	}
+	for _, op := range Operations() {
+		if !registered[op.Method+" "+op.Path] {
+			t.Errorf("operation %s %s is documented but not registered", op.Method, op.Path)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/openapi"
+)
+
+// Register - adds every route of the service to r, api middleware runs only for /api routes
+// except hls ones. Routes added here must be documented in Operations
+func Register(r gin.IRouter, h *Handlers, doc *openapi.Document, metrics http.Handler, api ...gin.HandlerFunc) {
+	r.GET("/healthz", h.Healthz)
+	r.GET("/readyz", h.Readyz)
+	r.GET("/openapi.json", OpenAPI(doc))
+	r.GET("/metrics", gin.WrapH(metrics))
+
+	// hls players request playlist and segment uris without credentials, segments are immutable
+	// and cached publicly anyway, so the routes are public like files on a cdn
+	hlsGroup := r.Group("/api/v1/hls")
+	{
+		hlsGroup.GET("/playlist/:id", h.GetHLSPlaylist)
+		hlsGroup.GET("/segment/:id", h.GetHLSSegment)
+	}
+
+	apiGroup := r.Group("/api", api...)
+
+	apiv1 := apiGroup.Group("/v1")
+	{
+		apiv1.POST("/addSegment", h.AddSegments)
+		apiv1.POST("/upload", h.UploadSegments)
+		apiv1.GET("/allsongs", h.GetAllSongs)
+		apiv1.GET("/songs", h.GetSongs)
+		apiv1.GET("/search", h.Search)
+		apiv1.POST("/getsegment", h.GetSegment)
+		apiv1.GET("/segment/:id", h.GetRawSegment)
+		apiv1.POST("/update_song", h.UpdateSong)
+		apiv1.POST("/delete_song", h.DeleteSong)
+		apiv1.POST("/new_user", h.NewUser)
+		apiv1.POST("/get_user", h.GetUser)
+		apiv1.POST("/set_user_roles", h.SetUserRoles)
+
+		// playlists
+		apiv1.POST("/new_playlist", h.NewPlaylist)
+		apiv1.POST("/delete_playlist", h.DeletePlaylist)
+		apiv1.POST("/user_playlists", h.GetUserPlaylists)
+		apiv1.POST("/get_playlist", h.GetUserPlaylist)
+		apiv1.POST("/add_song_playlist", h.AddSongToUserPlaylist)
+		apiv1.POST("/remove_song_playlist", h.RemoveSongFromUserPlaylist)
+	}
+
+	apiv2 := apiGroup.Group("/v2")
+	{
+		apiv2.GET("/users/:id", h.GetUserV2)
+		apiv2.GET("/users/:id/playlists", h.GetUserPlaylistsV2)
+		apiv2.DELETE("/playlists/:id", h.DeletePlaylistV2)
+		apiv2.PUT("/playlists/:id/songs/:songId", h.AddSongToPlaylistV2)
+
+		// resumable uploads
+		apiv2.POST("/uploads", h.CreateUploadV2)
+		apiv2.GET("/uploads/:id", h.GetUploadV2)
+		apiv2.PUT("/uploads/:id/m3h8/:segmentId", h.UploadM3H8V2)
+		apiv2.PUT("/uploads/:id/ts/:n/:segmentId", h.UploadTsV2)
+		apiv2.POST("/uploads/:id/commit", h.CommitUploadV2)
+		apiv2.DELETE("/uploads/:id", h.AbortUploadV2)
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/service"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.uber.org/zap"
+)
+
+const (
+	// UploadPartSongData - name of the first part of upload with song json
+	UploadPartSongData = "song_data"
+	// MaxUploadTs - max number of ts parts of upload, same as of AddSegmentsReq
+	MaxUploadTs = 2000
+	// maxSongDataSize - max size of song json part
+	maxSongDataSize = 64 << 10
+	// maxAddSegmentsSize - max size of AddSegments json: m3h8 and MaxUploadTs ts of max size in base64,
+	// each with room for its id and keys, and song json
+	maxAddSegmentsSize = (MaxUploadTs+1)*(int64(validation.MaxSegmentSize+2)/3*4+1<<10) + maxSongDataSize
+)
+
+// uploadForm - documentation of multipart upload, parts are read in order: song json, then m3h8
+// and ts files with segment id as file name
+type uploadForm struct {
+	SongData globalStructs.Song `json:"song_data" binding:"required"`
+	M3H8     []byte             `json:"m3h8" binding:"required"`
+	Ts       [][]byte           `json:"ts" binding:"required,min=1,max=2000"`
+}
+
+// UploadSegments - streaming multipart version of AddSegments, segments are passed to the service
+// as they are read so the request body is never held in memory. Body is limited by maxUpload
+// and every segment by validation.MaxSegmentSize
+func (h *Handlers) UploadSegments(c *gin.Context) {
+	var resp structs.AddSegmentsResp
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.maxUpload))
+
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		h.log(c).Error("error reading multipart upload", zap.Error(err))
+		resp.ErrorResp = structs.ErrorResp{Error: "multipart/form-data body is required", Code: structs.ErrCodeBadRequest}
+		resp.FailedPart = structs.IngestPartValidation
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	song, err := readSongData(mr)
+	if err != nil {
+		h.log(c).Error("error reading song data", zap.Error(err))
+		resp.ErrorResp = bodyError(err, int64(h.maxUpload))
+		resp.FailedPart = structs.IngestPartSongData
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err = h.s.UploadSegments(c.Request.Context(), song, h.segmentSource(mr))
+	if err != nil {
+		h.log(c).Error("error UploadSegments()", zap.Error(err), zap.String("failed_part", resp.FailedPart))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// readSongData - decodes and validates the first part of upload, which must be song json
+func readSongData(mr *multipart.Reader) (song globalStructs.Song, err error) {
+	p, err := mr.NextPart()
+	if err != nil {
+		return song, err
+	}
+	defer p.Close()
+
+	if p.FormName() != UploadPartSongData {
+		return song, errors.New("first part must be " + UploadPartSongData)
+	}
+	if err := json.NewDecoder(io.LimitReader(p, maxSongDataSize)).Decode(&song); err != nil {
+		return song, err
+	}
+	return song, validation.Struct(song)
+}
+
+// segmentSource - reads m3h8 and ts parts of upload one at a time
+func (h *Handlers) segmentSource(mr *multipart.Reader) service.SegmentSource {
+	var ts int
+	return func() (string, globalStructs.SongData, error) {
+		p, err := mr.NextPart()
+		if err != nil {
+			return "", globalStructs.SongData{}, h.uploadError(err)
+		}
+		defer p.Close()
+
+		part := p.FormName()
+		switch part {
+		case structs.IngestPartM3H8:
+		case structs.IngestPartTs:
+			if ts++; ts > MaxUploadTs {
+				return part, globalStructs.SongData{}, service.NewError(structs.ErrCodeTooLarge, "upload has more than %d ts", MaxUploadTs)
+			}
+		default:
+			return "", globalStructs.SongData{}, service.NewError(structs.ErrCodeValidation, "unknown upload part %q", part)
+		}
+
+		// one byte over the limit is enough for validation to reject the segment
+		data, err := io.ReadAll(io.LimitReader(p, validation.MaxSegmentSize+1))
+		if err != nil {
+			return part, globalStructs.SongData{}, h.uploadError(err)
+		}
+
+		segment := globalStructs.SongData{ID: p.FileName(), Data: data}
+		if err := validation.Struct(segment); err != nil {
+			return part, segment, service.NewError(structs.ErrCodeValidation, "invalid %s %q: %s", part, segment.ID, fieldsMessage(err))
+		}
+		return part, segment, nil
+	}
+}
+
+// uploadError - io.EOF is passed as is to end the upload, body over the limit is too large
+func (h *Handlers) uploadError(err error) error {
+	var merr *http.MaxBytesError
+	if errors.As(err, &merr) {
+		return service.NewError(structs.ErrCodeTooLarge, "upload is larger than %d bytes", h.maxUpload)
+	}
+	return err
+}
+
+// fieldsMessage - field errors of validation error in one line
+func fieldsMessage(err error) string {
+	fields := validation.Fields(err)
+	if len(fields) == 0 {
+		return err.Error()
+	}
+	msgs := make([]string, 0, len(fields))
+	for _, f := range fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return strings.Join(msgs, ", ")
+}
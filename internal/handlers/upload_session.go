@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.uber.org/zap"
+)
+
+// resumable upload sessions, segments are sent one per request as raw body so a failed one
+// is retried alone
+
+// CreateUploadV2 - POST /uploads
+func (h *Handlers) CreateUploadV2(c *gin.Context) {
+	var req structs.CreateUploadReq
+	var resp structs.UploadSessionResp
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.CreateUpload(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error creating upload", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetUploadV2 - GET /uploads/:id
+func (h *Handlers) GetUploadV2(c *gin.Context) {
+	var req structs.UploadSessionReq
+	var resp structs.UploadSessionResp
+	if err := bindPath(c, &req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.GetUpload(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error getting upload", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// UploadM3H8V2 - PUT /uploads/:id/m3h8/:segmentId
+func (h *Handlers) UploadM3H8V2(c *gin.Context) {
+	h.uploadSegment(c, structs.IngestPartM3H8)
+}
+
+// UploadTsV2 - PUT /uploads/:id/ts/:n/:segmentId
+func (h *Handlers) UploadTsV2(c *gin.Context) {
+	h.uploadSegment(c, structs.IngestPartTs)
+}
+
+// uploadSegment - body is data of the segment, it is limited by validation.MaxSegmentSize
+func (h *Handlers) uploadSegment(c *gin.Context, part string) {
+	var req structs.UploadSegmentReq
+	var resp structs.UploadSessionResp
+	if err := bindPath(c, &req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	// one byte over the limit is enough for validation to reject the segment
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, validation.MaxSegmentSize+1))
+	if err != nil {
+		h.log(c).Error("error reading segment", zap.Error(err))
+		resp.ErrorResp = structs.ErrorResp{Error: "error reading segment", Code: structs.ErrCodeBadRequest}
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+	segment := globalStructs.SongData{ID: req.SegmentID, Data: data}
+	if err := validation.Struct(segment); err != nil {
+		h.log(c).Error("invalid segment", zap.Error(err), logging.Any("req", req))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err = h.s.UploadSegment(c.Request.Context(), req, part, segment)
+	if err != nil {
+		h.log(c).Error("error uploading segment", zap.Error(err), zap.String("part", part), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// CommitUploadV2 - POST /uploads/:id/commit
+func (h *Handlers) CommitUploadV2(c *gin.Context) {
+	var req structs.UploadSessionReq
+	var resp structs.CommitUploadResp
+	if err := bindPath(c, &req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.CommitUpload(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error committing upload", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// AbortUploadV2 - DELETE /uploads/:id
+func (h *Handlers) AbortUploadV2(c *gin.Context) {
+	var req structs.UploadSessionReq
+	var resp structs.AbortUploadResp
+	if err := bindPath(c, &req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.AbortUpload(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error aborting upload", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
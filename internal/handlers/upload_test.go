@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+)
+
+// uploadPart - one part of multipart upload, song json when name is song_data and segment otherwise
+type uploadPart struct {
+	name, id string
+	data     []byte
+}
+
+func songPart(id string) uploadPart {
+	return uploadPart{name: UploadPartSongData, data: []byte(`{"id":"` + id + `","name":"name","artist":"artist","album":"album"}`)}
+}
+
+func segmentPart(name, id string) uploadPart {
+	return uploadPart{name: name, id: id, data: []byte(id)}
+}
+
+func multipartBody(t *testing.T, parts ...uploadPart) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for _, p := range parts {
+		var (
+			pw  io.Writer
+			err error
+		)
+		if p.id == "" {
+			pw, err = w.CreateFormField(p.name)
+		} else {
+			pw, err = w.CreateFormFile(p.name, p.id)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pw.Write(p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, w.FormDataContentType()
+}
+
+func TestUploadSegments(t *testing.T) {
+	manyTs := []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8")}
+	for i := 0; i <= MaxUploadTs; i++ {
+		manyTs = append(manyTs, segmentPart(structs.IngestPartTs, "ts"+strconv.Itoa(i)))
+	}
+
+	tests := []struct {
+		name       string
+		maxSize    int
+		parts      []uploadPart
+		status     int
+		code       string
+		failedPart string
+		stored     []string
+	}{
+		{
+			name:   "ok",
+			parts:  []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8"), segmentPart(structs.IngestPartTs, "ts0"), segmentPart(structs.IngestPartTs, "ts1")},
+			status: http.StatusOK,
+			stored: []string{"m3h8", "ts0", "ts1"},
+		},
+		{
+			name:   "m3h8 after ts",
+			parts:  []uploadPart{songPart("song"), segmentPart(structs.IngestPartTs, "ts0"), segmentPart(structs.IngestPartM3H8, "m3h8")},
+			status: http.StatusOK,
+			stored: []string{"m3h8", "ts0"},
+		},
+		{
+			name:       "song data is not first",
+			parts:      []uploadPart{segmentPart(structs.IngestPartM3H8, "m3h8"), songPart("song"), segmentPart(structs.IngestPartTs, "ts0")},
+			status:     http.StatusBadRequest,
+			code:       structs.ErrCodeBadRequest,
+			failedPart: structs.IngestPartSongData,
+		},
+		{
+			name:       "invalid song data",
+			parts:      []uploadPart{songPart(""), segmentPart(structs.IngestPartM3H8, "m3h8"), segmentPart(structs.IngestPartTs, "ts0")},
+			status:     http.StatusUnprocessableEntity,
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartSongData,
+		},
+		{
+			name:       "unknown part",
+			parts:      []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8"), segmentPart("mp3", "mp3")},
+			status:     http.StatusUnprocessableEntity,
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartValidation,
+		},
+		{
+			name:       "second m3h8",
+			parts:      []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8"), segmentPart(structs.IngestPartTs, "ts0"), segmentPart(structs.IngestPartM3H8, "m3h8-2")},
+			status:     http.StatusUnprocessableEntity,
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartM3H8,
+		},
+		{
+			name:       "no ts",
+			parts:      []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8")},
+			status:     http.StatusUnprocessableEntity,
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartTs,
+		},
+		{
+			name:       "too many ts",
+			parts:      manyTs,
+			status:     http.StatusRequestEntityTooLarge,
+			code:       structs.ErrCodeTooLarge,
+			failedPart: structs.IngestPartTs,
+		},
+		{
+			name: "oversized ts",
+			parts: []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8"),
+				{name: structs.IngestPartTs, id: "ts0", data: make([]byte, validation.MaxSegmentSize+1)}},
+			status:     http.StatusUnprocessableEntity,
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartTs,
+		},
+		{
+			name:    "body over max size in segments",
+			maxSize: 4 << 10,
+			parts: []uploadPart{songPart("song"), segmentPart(structs.IngestPartM3H8, "m3h8"),
+				{name: structs.IngestPartTs, id: "ts0", data: make([]byte, 8<<10)}},
+			status:     http.StatusRequestEntityTooLarge,
+			code:       structs.ErrCodeTooLarge,
+			failedPart: structs.IngestPartTs,
+		},
+		{
+			name:       "body over max size in song data",
+			maxSize:    256,
+			parts:      []uploadPart{{name: UploadPartSongData, data: bytes.Repeat([]byte(" "), 1<<10)}},
+			status:     http.StatusRequestEntityTooLarge,
+			code:       structs.ErrCodeTooLarge,
+			failedPart: structs.IngestPartSongData,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxSize := tt.maxSize
+			if maxSize == 0 {
+				maxSize = 64 << 20
+			}
+			r, mem := newTestRouter(t, config.Upload{MaxSize: maxSize})
+
+			body, contentType := multipartBody(t, tt.parts...)
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var resp structs.AddSegmentsResp
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || resp.Code != tt.code || resp.FailedPart != tt.failedPart {
+				t.Fatalf("got %d %q failed part %q, want %d %q %q: %s", w.Code, resp.Code, resp.FailedPart, tt.status, tt.code, tt.failedPart, resp.Error)
+			}
+
+			ctx := context.Background()
+			_, songErr := mem.GetSongByID(ctx, "song")
+			if songStored := songErr == nil; songStored != (tt.stored != nil) {
+				t.Errorf("song stored = %v, want %v", songStored, tt.stored != nil)
+			}
+			ids := []string{"m3h8", "m3h8-2", "ts0", "ts1", "mp3"}
+			found, err := mem.FindSegmentIDs(ctx, ids...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != len(tt.stored) {
+				t.Fatalf("stored segments %v, want %v", found, tt.stored)
+			}
+			for i := range found {
+				if found[i] != tt.stored[i] {
+					t.Errorf("stored segments %v, want %v", found, tt.stored)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	"go.uber.org/zap"
+)
+
+// ResourceCacheControl - Cache-Control of v2 GET responses, they are per user and may change
+// so clients keep them but revalidate with ETag every time
+const ResourceCacheControl = "private, no-cache"
+
+// v2 handlers are resource oriented versions of v1 ones, ids come from the path and the
+// rest of the request from the query, responses are the same as in v1
+
+// GetUserV2 - GET /users/:id
+func (h *Handlers) GetUserV2(c *gin.Context) {
+	var req structs.GetUserReq
+	var resp structs.GetUserResp
+	if err := c.ShouldBindUri(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.GetUser(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error getting user", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	h.cacheableJSON(c, resp)
+}
+
+// GetUserPlaylistsV2 - GET /users/:id/playlists
+func (h *Handlers) GetUserPlaylistsV2(c *gin.Context) {
+	var req structs.GetUserAllPlaylistsReq
+	var resp structs.GetUserAllPlaylistsResp
+	if err := c.ShouldBindUri(&req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.GetUserPlaylists(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error getting user playlists", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	h.cacheableJSON(c, resp)
+}
+
+// DeletePlaylistV2 - DELETE /playlists/:id, user_id query param is used like user_id of v1 body
+func (h *Handlers) DeletePlaylistV2(c *gin.Context) {
+	var req structs.DeleteUserPlaylistReq
+	var resp structs.DeleteUserPlaylistResp
+	if err := bindPath(c, &req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.DeleteUserPlaylist(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error deleting playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// AddSongToPlaylistV2 - PUT /playlists/:id/songs/:songId, user_id query param is used like user_id of v1 body
+func (h *Handlers) AddSongToPlaylistV2(c *gin.Context) {
+	var req structs.AddSongToUserPlaylistReq
+	var resp structs.AddSongToUserPlaylistResp
+	if err := bindPath(c, &req); err != nil {
+		h.log(c).Error("error binding req", zap.Error(err))
+		resp.ErrorResp = bindError(err)
+		c.JSON(codeStatus(resp.Code), resp)
+		return
+	}
+
+	resp, err := h.s.AddSongToUserPlaylist(c.Request.Context(), req)
+	if err != nil {
+		h.log(c).Error("error adding song to playlist", zap.Error(err), logging.Any("req", req))
+		c.JSON(status(err), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// bindPath - binds path and then query params, binding validates the request so path params
+// which are required go first
+func bindPath(c *gin.Context, req interface{}) error {
+	if err := c.ShouldBindUri(req); err != nil {
+		return err
+	}
+	return c.ShouldBindQuery(req)
+}
+
+// cacheableJSON - writes resp with ETag of its body, answers 304 when client already has it
+func (h *Handlers) cacheableJSON(c *gin.Context, resp interface{}) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		h.log(c).Error("error marshaling response", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, structs.ErrorResp{Error: "internal error", Code: structs.ErrCodeInternal})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", ResourceCacheControl)
+	if bytes.Contains([]byte(c.GetHeader("If-None-Match")), []byte(etag)) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+)
+
+// newV2Router - test router with user alice who owns playlist with the returned id and song "song"
+func newV2Router(t *testing.T) (http.Handler, db.IDB, string) {
+	t.Helper()
+	r, mem := newTestRouter(t, config.Upload{})
+	ctx := context.Background()
+	if err := mem.NewUser(ctx, globalStructs.User{ID: "alice", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.InsertSong(ctx, globalStructs.Song{ID: "song", Name: "name"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.NewPlaylist(ctx, globalStructs.Playlist{Name: "mine", OwnerID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	playlists, err := mem.GetAllUserPlaylists(ctx, "alice")
+	if err != nil || len(playlists) != 1 {
+		t.Fatalf("playlists = %+v, %v", playlists, err)
+	}
+	return r, mem, playlists[0].ID
+}
+
+func serve(r http.Handler, method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestV2Binding(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		// path - {playlist} is replaced with id of playlist of alice
+		path   string
+		body   string
+		status int
+		code   string
+		// fields - paths of failed fields in response
+		fields []string
+		// songs - songs of playlist of alice after the request, deleted - playlist is removed
+		songs   []string
+		deleted bool
+	}{
+		{name: "get user", method: http.MethodGet, path: "/api/v2/users/alice", status: http.StatusOK},
+		{name: "get missing user", method: http.MethodGet, path: "/api/v2/users/bob", status: http.StatusNotFound, code: structs.ErrCodeNotFound},
+		{name: "get user with invalid id", method: http.MethodGet, path: "/api/v2/users/al!ce", status: http.StatusUnprocessableEntity,
+			code: structs.ErrCodeValidation, fields: []string{"id"}},
+		{name: "get playlists", method: http.MethodGet, path: "/api/v2/users/alice/playlists", status: http.StatusOK},
+		{name: "get playlists with invalid user id", method: http.MethodGet, path: "/api/v2/users/al!ce/playlists", status: http.StatusUnprocessableEntity,
+			code: structs.ErrCodeValidation, fields: []string{"user_id"}},
+		{name: "add song", method: http.MethodPut, path: "/api/v2/playlists/{playlist}/songs/song?user_id=alice", status: http.StatusOK,
+			songs: []string{"song"}},
+		{name: "add song as other user", method: http.MethodPut, path: "/api/v2/playlists/{playlist}/songs/song?user_id=bob", status: http.StatusForbidden,
+			code: structs.ErrCodeForbidden},
+		{name: "add song with invalid song id", method: http.MethodPut, path: "/api/v2/playlists/{playlist}/songs/so%20ng?user_id=alice",
+			status: http.StatusUnprocessableEntity, code: structs.ErrCodeValidation, fields: []string{"song_id"}},
+		{name: "add song with invalid user_id query", method: http.MethodPut, path: "/api/v2/playlists/{playlist}/songs/song?user_id=al!ce",
+			status: http.StatusUnprocessableEntity, code: structs.ErrCodeValidation, fields: []string{"user_id"}},
+		{name: "delete playlist", method: http.MethodDelete, path: "/api/v2/playlists/{playlist}?user_id=alice", status: http.StatusOK, deleted: true},
+		{name: "delete playlist as other user", method: http.MethodDelete, path: "/api/v2/playlists/{playlist}?user_id=bob", status: http.StatusForbidden,
+			code: structs.ErrCodeForbidden},
+		{name: "delete playlist without user_id", method: http.MethodDelete, path: "/api/v2/playlists/{playlist}", status: http.StatusUnprocessableEntity,
+			code: structs.ErrCodeValidation},
+		{name: "delete missing playlist", method: http.MethodDelete, path: "/api/v2/playlists/missing?user_id=alice", status: http.StatusNotFound,
+			code: structs.ErrCodeNotFound},
+		{name: "create upload", method: http.MethodPost, path: "/api/v2/uploads",
+			body: `{"user_id":"alice","song_data":{"id":"new","name":"new"},"ts_count":2}`, status: http.StatusOK},
+		{name: "create upload with invalid body", method: http.MethodPost, path: "/api/v2/uploads",
+			body: `{"user_id":"alice","song_data":{"id":"new!","name":"new"},"ts_count":0}`, status: http.StatusUnprocessableEntity,
+			code: structs.ErrCodeValidation, fields: []string{"song_data.id", "ts_count"}},
+		{name: "get missing upload", method: http.MethodGet, path: "/api/v2/uploads/missing?user_id=alice", status: http.StatusNotFound,
+			code: structs.ErrCodeNotFound},
+		{name: "upload ts with negative index", method: http.MethodPut, path: "/api/v2/uploads/missing/ts/-1/ts0?user_id=alice", body: "ts",
+			status: http.StatusUnprocessableEntity, code: structs.ErrCodeValidation, fields: []string{"n"}},
+		{name: "upload ts with index which is not a number", method: http.MethodPut, path: "/api/v2/uploads/missing/ts/first/ts0?user_id=alice", body: "ts",
+			status: http.StatusBadRequest, code: structs.ErrCodeBadRequest},
+		{name: "upload empty m3h8", method: http.MethodPut, path: "/api/v2/uploads/missing/m3h8/m3h8?user_id=alice",
+			status: http.StatusUnprocessableEntity, code: structs.ErrCodeValidation, fields: []string{"data"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mem, playlist := newV2Router(t)
+			var header http.Header
+			if tt.body != "" {
+				header = http.Header{"Content-Type": {"application/json"}}
+			}
+			w := serve(r, tt.method, strings.ReplaceAll(tt.path, "{playlist}", playlist), header, tt.body)
+
+			var resp structs.ErrorResp
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || resp.Code != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", w.Code, resp.Code, tt.status, tt.code, w.Body)
+			}
+			var fields []string
+			for _, f := range resp.Fields {
+				fields = append(fields, f.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+
+			p, err := mem.GetPlaylistByID(context.Background(), playlist)
+			if tt.deleted != (err == db.ErrNotFound) {
+				t.Fatalf("playlist = %+v, %v, want deleted %v", p, err, tt.deleted)
+			}
+			var songs []string
+			for _, s := range p.Songs {
+				songs = append(songs, s.ID)
+			}
+			if strings.Join(songs, ",") != strings.Join(tt.songs, ",") {
+				t.Errorf("songs = %v, want %v", songs, tt.songs)
+			}
+		})
+	}
+}
+
+func TestV2ETag(t *testing.T) {
+	r, mem, _ := newV2Router(t)
+
+	first := serve(r, http.MethodGet, "/api/v2/users/alice/playlists", nil, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("Cache-Control") != ResourceCacheControl {
+		t.Fatalf("got %d with ETag %q and Cache-Control %q", first.Code, etag, first.Header().Get("Cache-Control"))
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"same etag", etag, http.StatusNotModified},
+		{"etag in list", `"other", ` + etag, http.StatusNotModified},
+		{"other etag", `"other"`, http.StatusOK},
+		{"no etag", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			if tt.ifNoneMatch != "" {
+				header = http.Header{"If-None-Match": {tt.ifNoneMatch}}
+			}
+			w := serve(r, http.MethodGet, "/api/v2/users/alice/playlists", header, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("etag = %q, want %q", got, etag)
+			}
+			if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 has body %q", w.Body)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != first.Body.String() {
+				t.Errorf("body = %s, want %s", w.Body, first.Body)
+			}
+		})
+	}
+
+	t.Run("changed resource", func(t *testing.T) {
+		if err := mem.NewPlaylist(context.Background(), globalStructs.Playlist{Name: "another", OwnerID: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+		w := serve(r, http.MethodGet, "/api/v2/users/alice/playlists", http.Header{"If-None-Match": {etag}}, "")
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Fatalf("got %d with ETag %q after change", w.Code, w.Header().Get("ETag"))
+		}
+		var resp structs.GetUserAllPlaylistsResp
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Playlists) != 2 {
+			t.Errorf("playlists = %+v, %v", resp.Playlists, err)
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		w := serve(r, http.MethodGet, "/api/v2/users/bob", nil, "")
+		if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" {
+			t.Errorf("got %d with ETag %q", w.Code, w.Header().Get("ETag"))
+		}
+	})
+}
@@ -0,0 +1,63 @@
+// Package hls works with stored m3u8 playlists so they can be served to standard HLS players
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+)
+
+const (
+	PlaylistContentType = "application/vnd.apple.mpegurl"
+	SegmentContentType  = "video/mp2t"
+)
+
+// IsPlaylist - checks that data is m3u8 playlist
+func IsPlaylist(data []byte) bool {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("#EXTM3U"))
+}
+
+// SegmentID - id of stored segment referenced by playlist uri, it is the last path element
+// of the uri without query and fragment
+func SegmentID(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+	return path.Base(uri)
+}
+
+// RewritePlaylist - replaces every segment uri of the playlist with segmentURI(id),
+// tags, comments and empty lines are kept as they are
+func RewritePlaylist(data []byte, segmentURI func(id string) string) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			line = segmentURI(SegmentID(trimmed))
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// SegmentIDs - ids of stored segments referenced by playlist in order they are played
+func SegmentIDs(data []byte) []string {
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		trimmed := strings.TrimSpace(scanner.Text())
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			ids = append(ids, SegmentID(trimmed))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,165 @@
+// Package logging builds service logger from config, carries request scoped loggers in contexts
+// and redacts segment data and user PII from logged values
+package logging
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// Redacted - logged in place of sensitive values
+	Redacted = "[redacted]"
+	// MaxItems - max number of logged items of slices, the rest is only counted
+	MaxItems = 10
+)
+
+// SensitiveFields - lower case json names of fields and map keys which are never logged: PII of
+// globalStructs.User and credentials of request headers
+var SensitiveFields = map[string]bool{
+	"password":      true,
+	"email":         true,
+	"username":      true,
+	"authorization": true,
+	"cookie":        true,
+	"token":         true,
+}
+
+// New - production json logger or development console logger of given level
+func New(cfg config.Log) (*zap.Logger, error) {
+	zcfg := zap.NewProductionConfig()
+	if cfg.Development {
+		zcfg = zap.NewDevelopmentConfig()
+	}
+	if cfg.Level != "" {
+		level, err := zapcore.ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, err
+		}
+		zcfg.Level = zap.NewAtomicLevelAt(level)
+	}
+	return zcfg.Build()
+}
+
+type ctxKey struct{}
+
+// NewContext - returns ctx carrying logger
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext - logger of ctx, fallback if ctx has none
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// Any - like zap.Any but logs v with Redact
+func Any(key string, v interface{}) zap.Field {
+	return zap.Any(key, Redact(v))
+}
+
+var (
+	bytesType = reflect.TypeOf([]byte(nil))
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// Redact - copy of v made of maps and slices for logging: byte slices are replaced by their size,
+// SensitiveFields by Redacted and slices are cut to MaxItems
+func Redact(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return redact(reflect.ValueOf(v))
+}
+
+func redact(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == bytesType:
+		return fmt.Sprintf("<%d bytes>", v.Len())
+	case v.Type() == timeType:
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		result := map[string]interface{}{}
+		redactFields(v, result)
+		return result
+	case reflect.Slice, reflect.Array:
+		n := v.Len()
+		if n > MaxItems {
+			n = MaxItems
+		}
+		result := make([]interface{}, 0, n+1)
+		for i := 0; i < n; i++ {
+			result = append(result, redact(v.Index(i)))
+		}
+		if v.Len() > n {
+			result = append(result, fmt.Sprintf("<%d more>", v.Len()-n))
+		}
+		return result
+	case reflect.Map:
+		result := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			if SensitiveFields[strings.ToLower(k)] {
+				result[k] = Redacted
+				continue
+			}
+			result[k] = redact(iter.Value())
+		}
+		return result
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// redactFields - adds fields of struct v to result by json name, fields of embedded structs
+// are added in place like encoding/json does
+func redactFields(v reflect.Value, result map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && tag == "" {
+			redactFields(v.Field(i), result)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if SensitiveFields[strings.ToLower(name)] {
+			result[name] = Redacted
+			continue
+		}
+		result[name] = redact(v.Field(i))
+	}
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want interface{}
+	}{
+		{
+			name: "segment data is replaced by its size",
+			v:    globalStructs.SongData{ID: "ts0", Data: []byte("segment")},
+			want: map[string]interface{}{"id": "ts0", "data": "<7 bytes>"},
+		},
+		{
+			name: "segments of nested request",
+			v: structs.AddSegmentsReq{
+				M3H8: globalStructs.SongData{ID: "m3h8", Data: []byte("#EXTM3U")},
+				Ts:   []globalStructs.SongData{{ID: "ts0", Data: []byte("ts")}},
+			},
+			want: map[string]interface{}{
+				"m3h8":      map[string]interface{}{"id": "m3h8", "data": "<7 bytes>"},
+				"ts":        []interface{}{map[string]interface{}{"id": "ts0", "data": "<2 bytes>"}},
+				"song_data": map[string]interface{}{"id": "", "name": "", "artist": "", "album": ""},
+			},
+		},
+		{
+			name: "user PII",
+			v:    &globalStructs.User{ID: "u1", Username: "alice", Email: "a@example.com", Password: "secret"},
+			want: map[string]interface{}{"id": "u1", "username": Redacted, "email": Redacted, "password": Redacted},
+		},
+		{
+			name: "credential headers",
+			v: http.Header{
+				"Authorization": {"Bearer token"},
+				"Cookie":        {"session=1"},
+				"X-Request-Id":  {"abc"},
+			},
+			want: map[string]interface{}{
+				"Authorization": Redacted,
+				"Cookie":        Redacted,
+				"X-Request-Id":  []interface{}{"abc"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Redact() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+)
+
+// DB - IDB decorator which observes latency and errors of every call, ingested segments
+// and playlist mutations
+type DB struct {
+	d db.IDB
+	m *Metrics
+}
+
+// NewDB - wraps d with metrics m
+func NewDB(d db.IDB, m *Metrics) *DB {
+	return &DB{d: d, m: m}
+}
+
+// observe - records call of method started at start, returns err unchanged
+func (d *DB) observe(method string, start time.Time, err error) error {
+	d.m.DBDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		d.m.DBErrors.WithLabelValues(method, errorKind(err)).Inc()
+	}
+	return err
+}
+
+// playlist - counts successful playlist mutation
+func (d *DB) playlist(operation string, err error) error {
+	return d.playlists(operation, 1, err)
+}
+
+// playlists - counts n successful playlist mutations
+func (d *DB) playlists(operation string, n int, err error) error {
+	if err == nil && n > 0 {
+		d.m.PlaylistMutations.WithLabelValues(operation).Add(float64(n))
+	}
+	return err
+}
+
+// errorKind - low cardinality label of db error
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, db.ErrForbidden):
+		return "forbidden"
+	case db.IsDup(err):
+		return "duplicate"
+	case errors.Is(err, db.ErrConflict):
+		return "conflict"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "other"
+}
+
+func (d *DB) GetAllSongs(ctx context.Context) (result []globalStructs.Song, err error) {
+	defer func(start time.Time) { d.observe("GetAllSongs", start, err) }(time.Now())
+	return d.d.GetAllSongs(ctx)
+}
+
+func (d *DB) GetSongs(ctx context.Context, q db.SongsQuery) (result []globalStructs.Song, total int, err error) {
+	defer func(start time.Time) { d.observe("GetSongs", start, err) }(time.Now())
+	return d.d.GetSongs(ctx, q)
+}
+
+func (d *DB) SearchSongs(ctx context.Context, q db.SearchQuery) (result []globalStructs.Song, total int, err error) {
+	defer func(start time.Time) { d.observe("SearchSongs", start, err) }(time.Now())
+	return d.d.SearchSongs(ctx, q)
+}
+
+func (d *DB) GetSegment(ctx context.Context, id string) (result globalStructs.SongData, err error) {
+	defer func(start time.Time) { d.observe("GetSegment", start, err) }(time.Now())
+	return d.d.GetSegment(ctx, id)
+}
+
+func (d *DB) GetSegmentSongID(ctx context.Context, id string) (songID string, err error) {
+	defer func(start time.Time) { d.observe("GetSegmentSongID", start, err) }(time.Now())
+	return d.d.GetSegmentSongID(ctx, id)
+}
+
+func (d *DB) GetSegmentInfo(ctx context.Context, id string) (result globalStructs.SongData, created time.Time, err error) {
+	defer func(start time.Time) { d.observe("GetSegmentInfo", start, err) }(time.Now())
+	return d.d.GetSegmentInfo(ctx, id)
+}
+
+// InsertSegment - counts segments and bytes which were written, also when some of them failed.
+// Segments which the service rolls back later when upload fails are not subtracted
+func (d *DB) InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) error {
+	start := time.Now()
+	err := d.observe("InsertSegment", start, d.d.InsertSegment(ctx, songID, ts...))
+
+	written := inserted(ts, err)
+	var size int
+	for _, v := range written {
+		size += len(v.Data)
+	}
+	d.m.IngestedSegments.Add(float64(len(written)))
+	d.m.IngestedBytes.Add(float64(size))
+	return err
+}
+
+// inserted - segments of ts written by InsertSegment which returned err. With *db.InsertSegmentsError
+// they are the first Inserted segments which did not fail, segments after a failed batch are
+// neither written nor listed as failed
+func inserted(ts []globalStructs.SongData, err error) []globalStructs.SongData {
+	if err == nil {
+		return ts
+	}
+	var ierr *db.InsertSegmentsError
+	if !errors.As(err, &ierr) {
+		return nil
+	}
+
+	failed := make(map[int]bool, len(ierr.Failed))
+	for _, v := range ierr.Failed {
+		failed[v.Index] = true
+	}
+	written := make([]globalStructs.SongData, 0, ierr.Inserted)
+	for i, v := range ts {
+		if len(written) == ierr.Inserted {
+			break
+		}
+		if !failed[i] {
+			written = append(written, v)
+		}
+	}
+	return written
+}
+
+func (d *DB) FindSegmentIDs(ctx context.Context, ids ...string) (found []string, err error) {
+	defer func(start time.Time) { d.observe("FindSegmentIDs", start, err) }(time.Now())
+	return d.d.FindSegmentIDs(ctx, ids...)
+}
+
+func (d *DB) DeleteSegments(ctx context.Context, songID string, ids ...string) error {
+	start := time.Now()
+	return d.observe("DeleteSegments", start, d.d.DeleteSegments(ctx, songID, ids...))
+}
+
+func (d *DB) InsertSong(ctx context.Context, s globalStructs.Song) error {
+	start := time.Now()
+	return d.observe("InsertSong", start, d.d.InsertSong(ctx, s))
+}
+
+func (d *DB) GetSongByID(ctx context.Context, id string) (s globalStructs.Song, err error) {
+	defer func(start time.Time) { d.observe("GetSongByID", start, err) }(time.Now())
+	return d.d.GetSongByID(ctx, id)
+}
+
+func (d *DB) DeleteSong(ctx context.Context, id string, legacy []string, dryRun bool) (result db.DeleteSongResult, err error) {
+	defer func(start time.Time) { d.observe("DeleteSong", start, err) }(time.Now())
+	result, err = d.d.DeleteSong(ctx, id, legacy, dryRun)
+	if !dryRun {
+		d.playlists(PlaylistRemoveSong, len(result.Playlists), err)
+	}
+	return result, err
+}
+
+func (d *DB) UpdateSong(ctx context.Context, u db.SongUpdate) (playlists int, err error) {
+	defer func(start time.Time) { d.observe("UpdateSong", start, err) }(time.Now())
+	playlists, err = d.d.UpdateSong(ctx, u)
+	return playlists, d.playlists(PlaylistUpdateSong, playlists, err)
+}
+
+func (d *DB) GetUserByID(ctx context.Context, id string) (resp globalStructs.User, err error) {
+	defer func(start time.Time) { d.observe("GetUserByID", start, err) }(time.Now())
+	return d.d.GetUserByID(ctx, id)
+}
+
+func (d *DB) GetUserRoles(ctx context.Context, id string) (roles []string, err error) {
+	defer func(start time.Time) { d.observe("GetUserRoles", start, err) }(time.Now())
+	return d.d.GetUserRoles(ctx, id)
+}
+
+func (d *DB) SetUserRoles(ctx context.Context, id string, roles []string) error {
+	start := time.Now()
+	return d.observe("SetUserRoles", start, d.d.SetUserRoles(ctx, id, roles))
+}
+
+func (d *DB) NewUser(ctx context.Context, u globalStructs.User) error {
+	start := time.Now()
+	return d.observe("NewUser", start, d.d.NewUser(ctx, u))
+}
+
+func (d *DB) NewPlaylist(ctx context.Context, p globalStructs.Playlist) error {
+	start := time.Now()
+	return d.playlist(PlaylistCreate, d.observe("NewPlaylist", start, d.d.NewPlaylist(ctx, p)))
+}
+
+func (d *DB) DeleteUserPlaylist(ctx context.Context, id, owner string) error {
+	start := time.Now()
+	return d.playlist(PlaylistDelete, d.observe("DeleteUserPlaylist", start, d.d.DeleteUserPlaylist(ctx, id, owner)))
+}
+
+func (d *DB) DeletePlaylistByID(ctx context.Context, id string) error {
+	start := time.Now()
+	return d.playlist(PlaylistDelete, d.observe("DeletePlaylistByID", start, d.d.DeletePlaylistByID(ctx, id)))
+}
+
+func (d *DB) GetPlaylistByID(ctx context.Context, id string) (p globalStructs.Playlist, err error) {
+	defer func(start time.Time) { d.observe("GetPlaylistByID", start, err) }(time.Now())
+	return d.d.GetPlaylistByID(ctx, id)
+}
+
+func (d *DB) AddSongsToUserPlaylist(ctx context.Context, id, owner string, song globalStructs.Song) error {
+	start := time.Now()
+	return d.playlist(PlaylistAddSong, d.observe("AddSongsToUserPlaylist", start, d.d.AddSongsToUserPlaylist(ctx, id, owner, song)))
+}
+
+func (d *DB) AddSongsToPlaylist(ctx context.Context, id string, song globalStructs.Song) error {
+	start := time.Now()
+	return d.playlist(PlaylistAddSong, d.observe("AddSongsToPlaylist", start, d.d.AddSongsToPlaylist(ctx, id, song)))
+}
+
+func (d *DB) RemoveSongFromUserPlaylist(ctx context.Context, id, owner, songID string) error {
+	start := time.Now()
+	return d.playlist(PlaylistRemoveSong, d.observe("RemoveSongFromUserPlaylist", start, d.d.RemoveSongFromUserPlaylist(ctx, id, owner, songID)))
+}
+
+func (d *DB) RemoveSongFromPlaylist(ctx context.Context, id, songID string) error {
+	start := time.Now()
+	return d.playlist(PlaylistRemoveSong, d.observe("RemoveSongFromPlaylist", start, d.d.RemoveSongFromPlaylist(ctx, id, songID)))
+}
+
+func (d *DB) GetAllUserPlaylists(ctx context.Context, owner string) (p []globalStructs.ShortPlaylist, err error) {
+	defer func(start time.Time) { d.observe("GetAllUserPlaylists", start, err) }(time.Now())
+	return d.d.GetAllUserPlaylists(ctx, owner)
+}
+
+func (d *DB) NewUploadSession(ctx context.Context, s db.UploadSession) (id string, err error) {
+	defer func(start time.Time) { d.observe("NewUploadSession", start, err) }(time.Now())
+	return d.d.NewUploadSession(ctx, s)
+}
+
+func (d *DB) GetUploadSession(ctx context.Context, id string) (s db.UploadSession, err error) {
+	defer func(start time.Time) { d.observe("GetUploadSession", start, err) }(time.Now())
+	return d.d.GetUploadSession(ctx, id)
+}
+
+func (d *DB) SetUploadSegment(ctx context.Context, id, key, segmentID string, expires time.Time) error {
+	start := time.Now()
+	return d.observe("SetUploadSegment", start, d.d.SetUploadSegment(ctx, id, key, segmentID, expires))
+}
+
+func (d *DB) DeleteUploadSession(ctx context.Context, id string) error {
+	start := time.Now()
+	return d.observe("DeleteUploadSession", start, d.d.DeleteUploadSession(ctx, id))
+}
+
+func (d *DB) ExpiredUploadSessions(ctx context.Context, now time.Time, limit int) (result []db.UploadSession, err error) {
+	defer func(start time.Time) { d.observe("ExpiredUploadSessions", start, err) }(time.Now())
+	return d.d.ExpiredUploadSessions(ctx, now, limit)
+}
+
+func (d *DB) Ping(ctx context.Context) error {
+	start := time.Now()
+	return d.observe("Ping", start, d.d.Ping(ctx))
+}
+
+func (d *DB) Close(ctx context.Context) error {
+	return d.d.Close(ctx)
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.uber.org/zap"
+)
+
+func TestInsertSegmentCounts(t *testing.T) {
+	ctx := context.Background()
+	mem := db.NewMemoryDB(zap.NewNop())
+	if err := mem.InsertSegment(ctx, "other", globalStructs.SongData{ID: "dup", Data: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	m := New(prometheus.NewRegistry())
+	d := NewDB(mem, m)
+
+	err := d.InsertSegment(ctx, "song",
+		globalStructs.SongData{ID: "a", Data: []byte("12")},
+		globalStructs.SongData{ID: "dup", Data: []byte("1234")},
+		globalStructs.SongData{ID: "b", Data: []byte("123")},
+	)
+	if _, ok := err.(*db.InsertSegmentsError); !ok {
+		t.Fatalf("err = %v, want *db.InsertSegmentsError", err)
+	}
+	if got := testutil.ToFloat64(m.IngestedSegments); got != 2 {
+		t.Errorf("ingested segments = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.IngestedBytes); got != 5 {
+		t.Errorf("ingested bytes = %v, want 5", got)
+	}
+}
+
+func TestInserted(t *testing.T) {
+	ts := []globalStructs.SongData{{ID: "0"}, {ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}}
+	// first batch 0-1 is written, 3 fails in the second batch, 4 is never sent
+	err := &db.InsertSegmentsError{Inserted: 3, Failed: []db.SegmentError{{Index: 3, ID: "3"}}}
+
+	written := inserted(ts, err)
+	var ids []string
+	for _, v := range written {
+		ids = append(ids, v.ID)
+	}
+	if len(ids) != 3 || ids[0] != "0" || ids[1] != "1" || ids[2] != "2" {
+		t.Errorf("inserted = %q, want [0 1 2]", ids)
+	}
+	if got := inserted(ts, context.Canceled); len(got) != 0 {
+		t.Errorf("inserted on other error = %d segments", len(got))
+	}
+}
+
+func TestSongChangesCountPlaylistMutations(t *testing.T) {
+	ctx := context.Background()
+	mem := db.NewMemoryDB(zap.NewNop())
+	song := globalStructs.Song{ID: "song", Name: "name"}
+	if err := mem.InsertSong(ctx, song); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []globalStructs.Playlist{
+		{Name: "first", OwnerID: "alice", Songs: []globalStructs.Song{song}},
+		{Name: "second", OwnerID: "alice", Songs: []globalStructs.Song{song, song}},
+		{Name: "without song", OwnerID: "alice"},
+	} {
+		if err := mem.NewPlaylist(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := New(prometheus.NewRegistry())
+	d := NewDB(mem, m)
+	updated := m.PlaylistMutations.WithLabelValues(PlaylistUpdateSong)
+	removed := m.PlaylistMutations.WithLabelValues(PlaylistRemoveSong)
+
+	name := "new name"
+	if _, err := d.UpdateSong(ctx, db.SongUpdate{ID: "song", Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.UpdateSong(ctx, db.SongUpdate{ID: "missing", Name: &name}); err == nil {
+		t.Fatal("update of missing song succeeded")
+	}
+	if got := testutil.ToFloat64(updated); got != 2 {
+		t.Errorf("update_song mutations = %v, want 2", got)
+	}
+
+	if _, err := d.DeleteSong(ctx, "song", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(removed); got != 0 {
+		t.Errorf("remove_song mutations after dry run = %v, want 0", got)
+	}
+	if _, err := d.DeleteSong(ctx, "song", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(removed); got != 2 {
+		t.Errorf("remove_song mutations = %v, want 2", got)
+	}
+}
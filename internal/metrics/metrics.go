@@ -0,0 +1,83 @@
+// Package metrics defines prometheus metrics of http api, db calls, ingestion and playlists
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Namespace - prefix of all metric names
+const Namespace = "spotify_db"
+
+// playlist operations of PlaylistMutations
+const (
+	PlaylistCreate     = "create"
+	PlaylistDelete     = "delete"
+	PlaylistAddSong    = "add_song"
+	PlaylistRemoveSong = "remove_song"
+	// PlaylistUpdateSong - copy of song in playlist is updated by UpdateSong
+	PlaylistUpdateSong = "update_song"
+)
+
+type Metrics struct {
+	// HTTPRequests - finished requests by method, route and status
+	HTTPRequests *prometheus.CounterVec
+	// HTTPDuration - request latency by method and route
+	HTTPDuration *prometheus.HistogramVec
+	// DBDuration - latency of IDB calls by method
+	DBDuration *prometheus.HistogramVec
+	// DBErrors - failed IDB calls by method and kind of error
+	DBErrors *prometheus.CounterVec
+	// IngestedSegments, IngestedBytes - stored m3h8 and ts segments and their data size
+	IngestedSegments prometheus.Counter
+	IngestedBytes    prometheus.Counter
+	// PlaylistMutations - successful playlist changes by operation, songs removed by DeleteSong
+	// and updated by UpdateSong count once per changed playlist, failed or partial calls are not counted
+	PlaylistMutations *prometheus.CounterVec
+}
+
+// New - creates metrics and registers them with go and process collectors in reg
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace, Subsystem: "http", Name: "requests_total",
+			Help: "Finished http requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace, Subsystem: "http", Name: "request_duration_seconds",
+			Help:    "Latency of http requests by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		DBDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace, Subsystem: "db", Name: "call_duration_seconds",
+			Help:    "Latency of db calls by method.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"method"}),
+		DBErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace, Subsystem: "db", Name: "errors_total",
+			Help: "Failed db calls by method and kind of error.",
+		}, []string{"method", "kind"}),
+		IngestedSegments: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Subsystem: "ingest", Name: "segments_total",
+			Help: "Stored m3h8 and ts segments.",
+		}),
+		IngestedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Subsystem: "ingest", Name: "bytes_total",
+			Help: "Size of stored segments data.",
+		}),
+		PlaylistMutations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace, Subsystem: "playlist", Name: "mutations_total",
+			Help: "Successful playlist changes by operation.",
+		}, []string{"operation"}),
+	}
+
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.HTTPRequests, m.HTTPDuration,
+		m.DBDuration, m.DBErrors,
+		m.IngestedSegments, m.IngestedBytes,
+		m.PlaylistMutations,
+	)
+	return m
+}
@@ -0,0 +1,352 @@
+// Package openapi builds OpenAPI 3 document of the api from route descriptions,
+// schemas are derived from go types by reflection using json, form, uri and binding tags
+package openapi
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+)
+
+// Version - OpenAPI version of generated documents
+const Version = "3.0.3"
+
+// Operation - description of a single route. Body, Query and Response are values of go types,
+// Query fields are read from form tags and path params from :params of Path.
+// ContentType is set for responses which are not json and BodyContentType for bodies which are not json
+type Operation struct {
+	Method          string
+	Path            string
+	Summary         string
+	Tag             string
+	Body            interface{}
+	BodyContentType string
+	Query           interface{}
+	Response        interface{}
+	ContentType     string
+	// Public - route is served without authentication
+	Public bool
+}
+
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Security   []map[string][]string           `json:"security,omitempty"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+type operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+const bearerScheme = "bearer"
+
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// New - builds document of operations, every operation except public ones requires bearer token
+func New(info Info, ops []Operation) *Document {
+	d := &Document{
+		OpenAPI:  Version,
+		Info:     info,
+		Security: []map[string][]string{{bearerScheme: {}}},
+		Paths:    map[string]map[string]operation{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{
+				bearerScheme: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+
+	for _, op := range ops {
+		path := pathParam.ReplaceAllString(op.Path, "{$1}")
+		if d.Paths[path] == nil {
+			d.Paths[path] = map[string]operation{}
+		}
+		d.Paths[path][strings.ToLower(op.Method)] = d.operation(op)
+	}
+	return d
+}
+
+// Has - checks that route with gin path is documented
+func (d *Document) Has(method, path string) bool {
+	_, ok := d.Paths[pathParam.ReplaceAllString(path, "{$1}")][strings.ToLower(method)]
+	return ok
+}
+
+func (d *Document) operation(op Operation) operation {
+	o := operation{Summary: op.Summary, Responses: map[string]response{}}
+	if op.Tag != "" {
+		o.Tags = []string{op.Tag}
+	}
+	if op.Public {
+		o.Security = []map[string][]string{}
+	}
+
+	for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		o.Parameters = append(o.Parameters, parameter{Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	if op.Query != nil {
+		o.Parameters = append(o.Parameters, d.queryParams(reflect.TypeOf(op.Query))...)
+	}
+	if op.Body != nil {
+		contentType := op.BodyContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		o.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]mediaType{contentType: {Schema: d.schema(reflect.TypeOf(op.Body))}},
+		}
+	}
+
+	if op.ContentType != "" {
+		o.Responses["200"] = response{
+			Description: "OK",
+			Content:     map[string]mediaType{op.ContentType: {Schema: &Schema{Type: "string", Format: "binary"}}},
+		}
+		o.Responses["default"] = response{Description: "error, body is the error message"}
+		return o
+	}
+
+	content := map[string]mediaType{}
+	if op.Response != nil {
+		content["application/json"] = mediaType{Schema: d.schema(reflect.TypeOf(op.Response))}
+	}
+	o.Responses["200"] = response{Description: "OK", Content: content}
+	o.Responses["default"] = response{Description: "error, error and code fields of the body are set", Content: content}
+	return o
+}
+
+func (d *Document) queryParams(t reflect.Type) []parameter {
+	var params []parameter
+	for _, f := range fields(t) {
+		name := tagName(f, "form")
+		if name == "" {
+			continue
+		}
+		s := d.schema(f.Type)
+		required := applyRules(s, f.Tag.Get("binding"))
+		params = append(params, parameter{Name: name, In: "query", Required: required, Schema: s})
+	}
+	return params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema - schema of t, named structs are added to components and referenced
+func (d *Document) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return &Schema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.object(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// placeholder stops recursion of self referencing types
+			d.Components.Schemas[t.Name()] = &Schema{}
+			*d.Components.Schemas[t.Name()] = *d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for _, f := range fields(t) {
+		name := tagName(f, "json")
+		if name == "" {
+			continue
+		}
+		p := d.schema(f.Type)
+		if applyRules(p, f.Tag.Get("binding")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = p
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// fields - exported fields of struct with fields of embedded structs in place of them,
+// like encoding/json sees them
+func fields(t reflect.Type) []reflect.StructField {
+	var result []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			result = append(result, fields(f.Type)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// tagName - name of field in tag, field name if tag has no name and empty if field is skipped
+func tagName(f reflect.StructField, tag string) string {
+	v, ok := f.Tag.Lookup(tag)
+	if !ok {
+		if tag == "form" {
+			return ""
+		}
+		return f.Name
+	}
+	name := strings.Split(v, ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// applyRules - sets limits of binding rules on s, returns true if field is required.
+// Rules after dive are applied to items of arrays
+func applyRules(s *Schema, binding string) (required bool) {
+	if binding == "" {
+		return false
+	}
+	rules := strings.Split(binding, ",")
+	for i, r := range rules {
+		if r == "dive" {
+			if s.Items != nil && s.Items.Ref == "" {
+				applyRules(s.Items, strings.Join(rules[i+1:], ","))
+			}
+			break
+		}
+
+		name, param := r, ""
+		if j := strings.Index(r, "="); j >= 0 {
+			name, param = r[:j], r[j+1:]
+		}
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		case "id":
+			s.Pattern = validation.IDPattern
+			limit(s, "max", strconv.Itoa(validation.MaxIDLength))
+		case "min", "max":
+			limit(s, name, param)
+		}
+	}
+	return required
+}
+
+func limit(s *Schema, rule, param string) {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return
+	}
+	f := float64(n)
+	switch {
+	case s.Type == "string" && rule == "min":
+		s.MinLength = &n
+	case s.Type == "string":
+		s.MaxLength = &n
+	case s.Type == "array" && rule == "min":
+		s.MinItems = &n
+	case s.Type == "array":
+		s.MaxItems = &n
+	case rule == "min":
+		s.Minimum = &f
+	default:
+		s.Maximum = &f
+	}
+}
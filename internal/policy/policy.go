@@ -0,0 +1,71 @@
+// Package policy decides which roles may perform which operations
+package policy
+
+// user roles
+const (
+	// RoleListener - default role, can listen to songs and manage own playlists
+	RoleListener = "listener"
+	// RoleUploader - can upload, update and delete songs
+	RoleUploader = "uploader"
+	// RoleAdmin - can do everything uploader can, moderate any playlist and manage user roles
+	RoleAdmin = "admin"
+)
+
+// Roles - all known roles
+var Roles = []string{RoleListener, RoleUploader, RoleAdmin}
+
+type Action string
+
+// actions guarded by policy, actions not listed here are allowed to every authenticated user
+const (
+	ActionUploadSong       Action = "upload_song"
+	ActionManageSongs      Action = "manage_songs"
+	ActionModeratePlaylist Action = "moderate_playlist"
+	ActionManageUsers      Action = "manage_users"
+)
+
+type Policy struct {
+	// Grants - actions allowed to each role
+	Grants map[string][]Action
+	// Admins - ids of users who have admin role regardless of roles stored in db,
+	// used to grant the first admin
+	Admins []string
+}
+
+// New - default grants with given admins
+func New(admins []string) Policy {
+	return Policy{
+		Grants: map[string][]Action{
+			RoleUploader: {ActionUploadSong, ActionManageSongs},
+			RoleAdmin:    {ActionUploadSong, ActionManageSongs, ActionModeratePlaylist, ActionManageUsers},
+		},
+		Admins: admins,
+	}
+}
+
+// Roles - effective roles of user, users without stored roles are listeners.
+// stored is never modified
+func (p Policy) Roles(userID string, stored []string) []string {
+	roles := stored
+	if len(roles) == 0 {
+		roles = []string{RoleListener}
+	}
+	for _, id := range p.Admins {
+		if id == userID {
+			return append(append([]string(nil), roles...), RoleAdmin)
+		}
+	}
+	return roles
+}
+
+// Allowed - checks if any of roles grants action
+func (p Policy) Allowed(roles []string, action Action) bool {
+	for _, r := range roles {
+		for _, a := range p.Grants[r] {
+			if a == action {
+				return true
+			}
+		}
+	}
+	return false
+}
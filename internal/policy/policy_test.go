@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	actions := []Action{ActionUploadSong, ActionManageSongs, ActionModeratePlaylist, ActionManageUsers}
+	tests := []struct {
+		roles   []string
+		allowed []Action
+	}{
+		{nil, nil},
+		{[]string{RoleListener}, nil},
+		{[]string{RoleUploader}, []Action{ActionUploadSong, ActionManageSongs}},
+		{[]string{RoleAdmin}, actions},
+		{[]string{RoleListener, RoleUploader}, []Action{ActionUploadSong, ActionManageSongs}},
+		{[]string{"unknown"}, nil},
+	}
+
+	p := New(nil)
+	for _, tt := range tests {
+		for _, action := range actions {
+			want := false
+			for _, a := range tt.allowed {
+				want = want || a == action
+			}
+			if got := p.Allowed(tt.roles, action); got != want {
+				t.Errorf("roles %v allowed %s = %v, want %v", tt.roles, action, got, want)
+			}
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		stored []string
+		want   []string
+	}{
+		{"no stored roles", "alice", nil, []string{RoleListener}},
+		{"stored roles", "alice", []string{RoleUploader}, []string{RoleUploader}},
+		{"configured admin without stored roles", "root", nil, []string{RoleListener, RoleAdmin}},
+		{"configured admin with stored roles", "root", []string{RoleUploader}, []string{RoleUploader, RoleAdmin}},
+	}
+
+	p := New([]string{"root"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Roles(tt.userID, tt.stored); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("roles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolesDoesNotModifyStored(t *testing.T) {
+	p := New([]string{"root"})
+	// spare capacity would let append write into the array behind stored
+	backing := make([]string, 2, 4)
+	backing[0], backing[1] = RoleListener, RoleUploader
+	stored := backing[:1]
+
+	roles := p.Roles("root", stored)
+	if !reflect.DeepEqual(roles, []string{RoleListener, RoleAdmin}) {
+		t.Fatalf("roles = %v", roles)
+	}
+	if backing[1] != RoleUploader {
+		t.Errorf("array of stored roles was modified: %v", backing)
+	}
+	roles[0] = RoleAdmin
+	if stored[0] != RoleListener {
+		t.Errorf("roles share array with stored: %v", stored)
+	}
+}
@@ -0,0 +1,102 @@
+// Package search ranks songs against a text query, it is used by db implementations after they
+// select candidate songs so ranking does not depend on mongo
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// weights of song fields, match in name is worth more than match in artist or album
+const (
+	NameWeight   = 3
+	ArtistWeight = 2
+	AlbumWeight  = 1
+)
+
+// Document - searchable fields of a song
+type Document struct {
+	Name   string
+	Artist string
+	Album  string
+}
+
+// Result - position of matched document in Rank input and its score
+type Result struct {
+	Index int
+	Score float64
+}
+
+// Terms - splits query into lower case words, everything that is not a letter or a digit is a separator
+func Terms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Score - scores document against query terms, every term must match a word of some field
+// either exactly or as a prefix (for type-ahead), otherwise score is 0.
+// Exact word match counts twice as much as prefix match, and the whole query being the
+// beginning of a field gives that field weight as a bonus
+func Score(terms []string, doc Document) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{Terms(doc.Name), NameWeight},
+		{Terms(doc.Artist), ArtistWeight},
+		{Terms(doc.Album), AlbumWeight},
+	}
+
+	var score float64
+	for _, term := range terms {
+		var best float64
+		for _, f := range fields {
+			for _, w := range f.words {
+				var s float64
+				switch {
+				case w == term:
+					s = 2 * f.weight
+				case strings.HasPrefix(w, term):
+					s = f.weight
+				}
+				if s > best {
+					best = s
+				}
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+
+	phrase := strings.Join(terms, " ")
+	for _, f := range fields {
+		if strings.HasPrefix(strings.Join(f.words, " "), phrase) {
+			score += f.weight
+		}
+	}
+	return score
+}
+
+// Rank - returns matching documents sorted by score, documents with equal score keep input order
+func Rank(query string, docs []Document) []Result {
+	terms := Terms(query)
+	var results []Result
+	for i, d := range docs {
+		if s := Score(terms, d); s > 0 {
+			results = append(results, Result{Index: i, Score: s})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
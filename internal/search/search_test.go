@@ -0,0 +1,92 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"Hello, World-2!", []string{"hello", "world", "2"}},
+		{"  ", []string{}},
+		{"Café Tacvba", []string{"café", "tacvba"}},
+	}
+	for _, tt := range tests {
+		got := Terms(tt.query)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Terms(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	doc := Document{Name: "Love Song", Artist: "Adele", Album: "21"}
+	tests := []struct {
+		name  string
+		terms []string
+		want  float64
+	}{
+		{"exact name word with phrase bonus", []string{"love"}, 2*NameWeight + NameWeight},
+		{"prefix of name word with phrase bonus", []string{"lov"}, NameWeight + NameWeight},
+		{"second word of name", []string{"song"}, 2 * NameWeight},
+		{"terms in different fields", []string{"love", "adele"}, 2*NameWeight + 2*ArtistWeight},
+		{"every term must match", []string{"love", "missing"}, 0},
+		{"middle of word does not match", []string{"ove"}, 0},
+		{"no terms", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Score(tt.terms, doc); got != tt.want {
+				t.Errorf("Score(%q) = %v, want %v", tt.terms, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRank(t *testing.T) {
+	docs := []Document{
+		{Name: "Other", Artist: "Someone", Album: "Love"},
+		{Name: "Hello", Artist: "Love Band", Album: "x"},
+		{Name: "Lovely", Artist: "Billie", Album: "y"},
+		{Name: "Nothing", Artist: "Here", Album: "z"},
+		{Name: "Love Song", Artist: "Adele", Album: "21"},
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []Result
+	}{
+		{
+			name:  "name beats artist beats album, equal scores keep input order",
+			query: "love",
+			want: []Result{
+				{Index: 4, Score: 3 * NameWeight},
+				{Index: 1, Score: 3 * ArtistWeight},
+				{Index: 2, Score: 2 * NameWeight},
+				{Index: 0, Score: 3 * AlbumWeight},
+			},
+		},
+		{
+			name:  "all terms must match",
+			query: "love adele",
+			want:  []Result{{Index: 4, Score: 2*NameWeight + 2*ArtistWeight}},
+		},
+		{
+			name:  "nothing matches",
+			query: "zzz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Rank(tt.query, docs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rank(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
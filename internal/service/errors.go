@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+)
+
+// Error - typed error returned by Service, Code is one of structs.ErrCode* values
+// and tells handlers which http status to answer with
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError - error with code for callers which produce input of Service, e.g. SegmentSource
+func NewError(code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func validationError(format string, args ...interface{}) error {
+	return &Error{Code: structs.ErrCodeValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func forbiddenError(format string, args ...interface{}) error {
+	return &Error{Code: structs.ErrCodeForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func conflictError(format string, args ...interface{}) error {
+	return &Error{Code: structs.ErrCodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// dbError - wraps error of db call, code is decided by the kind of db error
+func dbError(err error, message string) error {
+	code := structs.ErrCodeInternal
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		code = structs.ErrCodeNotFound
+	case errors.Is(err, db.ErrForbidden):
+		code = structs.ErrCodeForbidden
+	case db.IsDup(err), errors.Is(err, db.ErrConflict):
+		code = structs.ErrCodeConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		code = structs.ErrCodeTimeout
+	}
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// Code - code of err, errors which are not *Error are internal
+func Code(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return structs.ErrCodeInternal
+}
+
+// errorResp - envelope of err sent to clients, details of internal errors are only logged
+func errorResp(err error) structs.ErrorResp {
+	var e *Error
+	if !errors.As(err, &e) {
+		return structs.ErrorResp{Error: "internal error", Code: structs.ErrCodeInternal}
+	}
+	if e.Code == structs.ErrCodeInternal {
+		return structs.ErrorResp{Error: e.Message, Code: e.Code}
+	}
+	return structs.ErrorResp{Error: e.Error(), Code: e.Code}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+)
+
+func TestDBError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code string
+		// message - error sent to clients
+		message string
+	}{
+		{"not found", db.ErrNotFound, structs.ErrCodeNotFound, "error getting song: not found"},
+		{"wrapped not found", fmt.Errorf("find: %w", db.ErrNotFound), structs.ErrCodeNotFound, "error getting song: find: not found"},
+		{"forbidden", db.ErrForbidden, structs.ErrCodeForbidden, "error getting song: playlist belongs to another user"},
+		{"duplicate", db.ErrDuplicate, structs.ErrCodeConflict, "error getting song: duplicate key"},
+		{"conflict", db.ErrConflict, structs.ErrCodeConflict, "error getting song: conflict"},
+		{"deadline", context.DeadlineExceeded, structs.ErrCodeTimeout, "error getting song: context deadline exceeded"},
+		{"all segments duplicate", &db.InsertSegmentsError{Failed: []db.SegmentError{{ID: "ts0", Err: db.ErrDuplicate}}},
+			structs.ErrCodeConflict, "error getting song: error inserting 1 segments: segment 0 (ts0): duplicate key"},
+		{"some segments failed", &db.InsertSegmentsError{Failed: []db.SegmentError{{ID: "ts0", Err: db.ErrDuplicate}, {Index: 1, ID: "ts1", Err: errors.New("write")}}},
+			structs.ErrCodeInternal, "error getting song"},
+		{"other db error", errors.New("connection reset"), structs.ErrCodeInternal, "error getting song"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbError(tt.err, "error getting song")
+			if got := Code(err); got != tt.code {
+				t.Errorf("code = %q, want %q", got, tt.code)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%v does not wrap %v", err, tt.err)
+			}
+			if resp := errorResp(err); resp.Code != tt.code || resp.Error != tt.message {
+				t.Errorf("resp = %+v, want %q %q", resp, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestErrorResp(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want structs.ErrorResp
+	}{
+		{"validation", validationError("name is required"), structs.ErrorResp{Error: "name is required", Code: structs.ErrCodeValidation}},
+		{"forbidden", forbiddenError("not owner"), structs.ErrorResp{Error: "not owner", Code: structs.ErrCodeForbidden}},
+		{"conflict", conflictError("taken"), structs.ErrorResp{Error: "taken", Code: structs.ErrCodeConflict}},
+		{"too large", NewError(structs.ErrCodeTooLarge, "over %d", 10), structs.ErrorResp{Error: "over 10", Code: structs.ErrCodeTooLarge}},
+		{"wrapped", fmt.Errorf("upload: %w", validationError("bad")), structs.ErrorResp{Error: "bad", Code: structs.ErrCodeValidation}},
+		{"untyped", errors.New("secret detail"), structs.ErrorResp{Error: "internal error", Code: structs.ErrCodeInternal}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := errorResp(tt.err)
+			if resp.Error != tt.want.Error || resp.Code != tt.want.Code {
+				t.Errorf("resp = %+v, want %+v", resp, tt.want)
+			}
+			if got := Code(tt.err); got != tt.want.Code {
+				t.Errorf("code = %q, want %q", got, tt.want.Code)
+			}
+		})
+	}
+}
@@ -1,101 +1,552 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/auth"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
 	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/hls"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/policy"
 	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
 	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
 	"go.uber.org/zap"
+	"io"
+	"strings"
 	"time"
 )
 
 type IService interface {
-	NewSegments(req structs.AddSegmentsReq) (resp structs.AddSegmentsResp, err error)
-	GetAllSongs() (resp structs.GetAllSongsResp, err error)
-	GetSegment(req structs.GetSegmentReq) (resp structs.GetSegmentResp, err error)
-	GetUser(req structs.GetUserReq) (resp structs.GetUserResp, err error)
-	NewUser(req globalStructs.User) (resp structs.NewUserResp, err error)
-	NewPlaylist(req structs.NewPlaylistReq) (resp structs.NewPlaylistResp, err error)
-	DeletePlaylist(req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error)
-	DeleteUserPlaylist(req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error)
-	AddSongToUserPlaylist(req structs.AddSongToUserPlaylistReq) (resp structs.AddSongToUserPlaylistResp, err error)
-	RemoveSongFromUserPlaylist(req structs.RemoveSongFromUserPlaylistReq) (resp structs.RemoveSongFromUserPlaylistResp, err error)
-	GetUserPlaylists(req structs.GetUserAllPlaylistsReq) (resp structs.GetUserAllPlaylistsResp, err error)
-	GetUserPlaylist(req structs.GetPlaylistReq) (resp structs.GetPlaylistResp, err error)
+	NewSegments(ctx context.Context, req structs.AddSegmentsReq) (resp structs.AddSegmentsResp, err error)
+	UploadSegments(ctx context.Context, song globalStructs.Song, next SegmentSource) (resp structs.AddSegmentsResp, err error)
+	GetAllSongs(ctx context.Context) (resp structs.GetAllSongsResp, err error)
+	GetSongs(ctx context.Context, req structs.GetSongsReq) (resp structs.GetSongsResp, err error)
+	Search(ctx context.Context, req structs.SearchReq) (resp structs.SearchResp, err error)
+	GetSegment(ctx context.Context, req structs.GetSegmentReq) (resp structs.GetSegmentResp, err error)
+	DeleteSong(ctx context.Context, req structs.DeleteSongReq) (resp structs.DeleteSongResp, err error)
+	UpdateSong(ctx context.Context, req structs.UpdateSongReq) (resp structs.UpdateSongResp, err error)
+	GetUser(ctx context.Context, req structs.GetUserReq) (resp structs.GetUserResp, err error)
+	NewUser(ctx context.Context, req globalStructs.User) (resp structs.NewUserResp, err error)
+	NewPlaylist(ctx context.Context, req structs.NewPlaylistReq) (resp structs.NewPlaylistResp, err error)
+	DeletePlaylist(ctx context.Context, req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error)
+	DeleteUserPlaylist(ctx context.Context, req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error)
+	AddSongToUserPlaylist(ctx context.Context, req structs.AddSongToUserPlaylistReq) (resp structs.AddSongToUserPlaylistResp, err error)
+	RemoveSongFromUserPlaylist(ctx context.Context, req structs.RemoveSongFromUserPlaylistReq) (resp structs.RemoveSongFromUserPlaylistResp, err error)
+	GetUserPlaylists(ctx context.Context, req structs.GetUserAllPlaylistsReq) (resp structs.GetUserAllPlaylistsResp, err error)
+	GetUserPlaylist(ctx context.Context, req structs.GetPlaylistReq) (resp structs.GetPlaylistResp, err error)
+	SetUserRoles(ctx context.Context, req structs.SetUserRolesReq) (resp structs.SetUserRolesResp, err error)
+	CreateUpload(ctx context.Context, req structs.CreateUploadReq) (resp structs.UploadSessionResp, err error)
+	GetUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.UploadSessionResp, err error)
+	UploadSegment(ctx context.Context, req structs.UploadSegmentReq, part string, segment globalStructs.SongData) (resp structs.UploadSessionResp, err error)
+	CommitUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.CommitUploadResp, err error)
+	AbortUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.AbortUploadResp, err error)
+	CleanupUploads(ctx context.Context) (removed int, err error)
+	Ready(ctx context.Context) error
 }
 
+// DefaultSongsPageLimit - page size of GetSongs when limit is not set
+const DefaultSongsPageLimit = 50
+
 type Service struct {
-	d      db.IDB
-	logger *zap.Logger
+	d          db.IDB
+	policy     policy.Policy
+	sessionTTL time.Duration
+	logger     *zap.Logger
+}
+
+// NewService - upload sessions live for cfg.SessionTTL after their last segment
+func NewService(d db.IDB, p policy.Policy, cfg config.Upload, l *zap.Logger) IService {
+	return &Service{d: d, policy: p, sessionTTL: cfg.SessionTTL, logger: l}
 }
 
-func NewService(d db.IDB, l *zap.Logger) IService {
-	return &Service{d: d, logger: l}
+// log - request logger of ctx, it carries request id
+func (s *Service) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, s.logger)
 }
 
-func (s *Service) NewSegments(req structs.AddSegmentsReq) (resp structs.AddSegmentsResp, err error) {
-	err = s.d.InsertSegment(req.M3H8)
+// NewSegments - inserts song with all its segments, ingestion is all or nothing:
+// request is validated before anything is written and already written segments are removed if
+// any later insert fails. resp.FailedPart tells which part of the request caused the error
+func (s *Service) NewSegments(ctx context.Context, req structs.AddSegmentsReq) (resp structs.AddSegmentsResp, err error) {
+	if err = s.authorize(ctx, policy.ActionUploadSong); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	if part, err := s.validateSegments(ctx, req); err != nil {
+		resp.FailedPart = part
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	err = s.d.InsertSegment(ctx, req.SongData.ID, req.M3H8)
 	if err != nil {
-		s.logger.Error("error inserting m3h8", zap.Error(err))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error inserting m3h8", zap.Error(err))
+		err = dbError(err, "error inserting m3h8")
+		resp.FailedPart = structs.IngestPartM3H8
+		resp.FailedSegments = []string{req.M3H8.ID}
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
-	err = s.d.InsertSegment(req.Ts...)
+	err = s.d.InsertSegment(ctx, req.SongData.ID, req.Ts...)
 	if err != nil {
-		s.logger.Error("error inserting ts", zap.Error(err))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error inserting ts", zap.Error(err))
+		resp.FailedPart = structs.IngestPartTs
+		written := segmentIDs(req)
+		if ierr, ok := err.(*db.InsertSegmentsError); ok {
+			resp.FailedSegments = ierr.FailedIDs()
+			written = without(written, resp.FailedSegments)
+		}
+		err = dbError(err, "error inserting ts")
+		resp.ErrorResp = errorResp(err)
+		if rbErr := s.rollbackSegments(ctx, req.SongData.ID, written); rbErr != nil {
+			resp.Error += "; rollback failed"
+		}
 		return resp, err
 	}
 
-	err = s.d.InsertSong(req.SongData)
+	err = s.d.InsertSong(ctx, req.SongData)
 	if err != nil {
-		s.logger.Error("error inserting song data", zap.Error(err), zap.Any("song_data", req.SongData))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error inserting song data", zap.Error(err), logging.Any("song_data", req.SongData))
+		err = dbError(err, "error inserting song data")
+		resp.FailedPart = structs.IngestPartSongData
+		resp.ErrorResp = errorResp(err)
+		if rbErr := s.rollbackSegments(ctx, req.SongData.ID, segmentIDs(req)); rbErr != nil {
+			resp.Error += "; rollback failed"
+		}
 		return resp, err
 	}
 	resp.OK = true
 	return resp, nil
 }
 
-func (s *Service) GetAllSongs() (resp structs.GetAllSongsResp, err error) {
-	songs, err := s.d.GetAllSongs()
+// SegmentSource - yields segments of streamed upload one at a time, part is structs.IngestPartM3H8 or
+// structs.IngestPartTs. It returns io.EOF after the last segment, any other error aborts the upload
+// and is answered with its code when it is *Error or as bad request otherwise
+type SegmentSource func() (part string, segment globalStructs.SongData, err error)
+
+// UploadSegments - streaming version of NewSegments, segments are written as next yields them so only
+// one is held in memory and song is inserted after the last one. Upload needs exactly one m3h8 and
+// at least one ts, segments written before any error are removed
+func (s *Service) UploadSegments(ctx context.Context, song globalStructs.Song, next SegmentSource) (resp structs.AddSegmentsResp, err error) {
+	if err = s.authorize(ctx, policy.ActionUploadSong); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	var written []string
+	fail := func(part string, failed []string, err error) (structs.AddSegmentsResp, error) {
+		resp.FailedPart = part
+		resp.FailedSegments = failed
+		resp.ErrorResp = errorResp(err)
+		if len(written) != 0 {
+			if rbErr := s.rollbackSegments(ctx, song.ID, written); rbErr != nil {
+				resp.Error += "; rollback failed"
+			}
+		}
+		return resp, err
+	}
+
+	_, err = s.d.GetSongByID(ctx, song.ID)
+	if err == nil {
+		return fail(structs.IngestPartSongData, nil, conflictError("song %s already exists", song.ID))
+	}
+	if err != db.ErrNotFound {
+		s.log(ctx).Error("error getting song by id", zap.Error(err), logging.Any("id", song.ID))
+		return fail(structs.IngestPartValidation, nil, dbError(err, "error getting song"))
+	}
+
+	seen := map[string]struct{}{}
+	var m3h8, ts int
+	for {
+		part, segment, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if part == "" {
+				part = structs.IngestPartValidation
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				err = &Error{Code: structs.ErrCodeBadRequest, Message: "error reading upload", Err: err}
+			}
+			return fail(part, nil, err)
+		}
+
+		switch part {
+		case structs.IngestPartM3H8:
+			m3h8++
+		case structs.IngestPartTs:
+			ts++
+		default:
+			return fail(structs.IngestPartValidation, nil, validationError("unknown upload part %s", part))
+		}
+		if m3h8 > 1 {
+			return fail(part, []string{segment.ID}, validationError("upload has more than one m3h8"))
+		}
+		if _, ok := seen[segment.ID]; ok {
+			return fail(part, []string{segment.ID}, validationError("duplicate segment id %s", segment.ID))
+		}
+		seen[segment.ID] = struct{}{}
+
+		if err := s.d.InsertSegment(ctx, song.ID, segment); err != nil {
+			s.log(ctx).Error("error inserting segment", zap.Error(err), zap.String("part", part), zap.String("id", segment.ID))
+			return fail(part, []string{segment.ID}, dbError(err, "error inserting "+part))
+		}
+		written = append(written, segment.ID)
+	}
+
+	if m3h8 == 0 {
+		return fail(structs.IngestPartM3H8, nil, validationError("m3h8 is required"))
+	}
+	if ts == 0 {
+		return fail(structs.IngestPartTs, nil, validationError("at least one ts is required"))
+	}
+
+	if err = s.d.InsertSong(ctx, song); err != nil {
+		s.log(ctx).Error("error inserting song data", zap.Error(err), logging.Any("song_data", song))
+		return fail(structs.IngestPartSongData, nil, dbError(err, "error inserting song data"))
+	}
+	resp.OK = true
+	return resp, nil
+}
+
+// validateSegments - checks that segment ids are unique and that nothing from the request is
+// already stored, fields of the request are validated when it is bound, returns failed part of the request with the error
+func (s *Service) validateSegments(ctx context.Context, req structs.AddSegmentsReq) (string, error) {
+	ids := segmentIDs(req)
+	unique := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := unique[id]; ok {
+			return structs.IngestPartTs, validationError("duplicate segment id %s", id)
+		}
+		unique[id] = struct{}{}
+	}
+
+	_, err := s.d.GetSongByID(ctx, req.SongData.ID)
+	if err == nil {
+		return structs.IngestPartSongData, conflictError("song %s already exists", req.SongData.ID)
+	}
+	if err != db.ErrNotFound {
+		s.log(ctx).Error("error getting song by id", zap.Error(err), logging.Any("id", req.SongData.ID))
+		return structs.IngestPartValidation, dbError(err, "error getting song")
+	}
+
+	found, err := s.d.FindSegmentIDs(ctx, ids...)
 	if err != nil {
-		s.logger.Error("error getting songs", zap.Error(err))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error finding segments", zap.Error(err))
+		return structs.IngestPartValidation, dbError(err, "error finding segments")
+	}
+	if len(found) != 0 {
+		part := structs.IngestPartTs
+		for _, id := range found {
+			if id == req.M3H8.ID {
+				part = structs.IngestPartM3H8
+			}
+		}
+		return part, conflictError("segments already exist: %s", strings.Join(found, ", "))
+	}
+
+	return "", nil
+}
+
+// rollbackSegments - removes segments of song written by NewSegments or UploadSegments. Only segments stored
+// for songID are removed, so ids which another song inserted meanwhile are kept. It drops cancellation
+// of request context so partially written song is cleaned up even if client has gone or request deadline passed
+func (s *Service) rollbackSegments(ctx context.Context, songID string, ids []string) error {
+	err := s.d.DeleteSegments(context.WithoutCancel(ctx), songID, ids...)
+	if err != nil {
+		s.log(ctx).Error("error rolling back segments", zap.Error(err), logging.Any("song_id", songID))
+	}
+	return err
+}
+
+// userID - id of the user request is made for: authenticated user, user service acts for or,
+// when the service did not say or auth is disabled, user id from request body
+func userID(ctx context.Context, bodyID string) (string, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok || (p.Service != "" && p.UserID == "") {
+		if bodyID == "" {
+			return "", validationError("user_id is required")
+		}
+		return bodyID, nil
+	}
+	if bodyID != "" && bodyID != p.UserID {
+		return "", forbiddenError("user_id does not match authenticated user")
+	}
+	return p.UserID, nil
+}
+
+// authorize - checks that caller may perform action, see allowed
+func (s *Service) authorize(ctx context.Context, action policy.Action) error {
+	ok, err := s.allowed(ctx, action)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return forbiddenError("%s is not allowed", action)
+	}
+	return nil
+}
+
+// allowed - checks roles of authenticated user against policy. Services acting on their own
+// and all callers when auth is disabled are trusted
+func (s *Service) allowed(ctx context.Context, action policy.Action) (bool, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok || p.UserID == "" {
+		return true, nil
+	}
+
+	stored, err := s.d.GetUserRoles(ctx, p.UserID)
+	if err != nil && err != db.ErrNotFound {
+		s.log(ctx).Error("error getting user roles", zap.Error(err), logging.Any("id", p.UserID))
+		return false, dbError(err, "error getting user roles")
+	}
+	return s.policy.Allowed(s.policy.Roles(p.UserID, stored), action), nil
+}
+
+// self - checks that authenticated user asks for own data, users allowed action may ask for
+// anyone's. Services acting on their own and callers when auth is disabled are trusted
+func (s *Service) self(ctx context.Context, id string, action policy.Action) error {
+	if p, ok := auth.FromContext(ctx); ok && p.UserID != "" && p.UserID == id {
+		return nil
+	}
+	return s.authorize(ctx, action)
+}
+
+// playlistOwner - owner playlist operation is limited to, empty when caller may change any
+// playlist: admins and services acting on their own without user_id in the body
+func (s *Service) playlistOwner(ctx context.Context, bodyID string) (string, error) {
+	p, ok := auth.FromContext(ctx)
+	if ok && p.UserID != "" {
+		moderator, err := s.allowed(ctx, policy.ActionModeratePlaylist)
+		if err != nil {
+			return "", err
+		}
+		if moderator {
+			return "", nil
+		}
+	}
+	if ok && p.UserID == "" && bodyID == "" {
+		return "", nil
+	}
+	return userID(ctx, bodyID)
+}
+
+// segmentIDs - returns m3h8 id followed by ts ids
+func segmentIDs(req structs.AddSegmentsReq) []string {
+	ids := make([]string, 0, len(req.Ts)+1)
+	ids = append(ids, req.M3H8.ID)
+	for _, v := range req.Ts {
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+// without - ids which are not in exclude
+func without(ids, exclude []string) []string {
+	skip := make(map[string]struct{}, len(exclude))
+	for _, id := range exclude {
+		skip[id] = struct{}{}
+	}
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := skip[id]; !ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func (s *Service) GetAllSongs(ctx context.Context) (resp structs.GetAllSongsResp, err error) {
+	songs, err := s.d.GetAllSongs(ctx)
+	if err != nil {
+		s.log(ctx).Error("error getting songs", zap.Error(err))
+		err = dbError(err, "error getting songs")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 	resp.Songs = songs
 	return
 }
 
-func (s *Service) GetSegment(req structs.GetSegmentReq) (resp structs.GetSegmentResp, err error) {
-	if req.ID == "" {
-		resp.Error = "id cannot be empty"
-		return resp, errors.New(resp.Error)
+func (s *Service) GetSongs(ctx context.Context, req structs.GetSongsReq) (resp structs.GetSongsResp, err error) {
+	if req.Limit == 0 {
+		req.Limit = DefaultSongsPageLimit
+	}
+	if req.Limit > db.GetAllSongsLimit {
+		req.Limit = db.GetAllSongsLimit
 	}
 
-	segment, err := s.d.GetSegment(req.ID)
+	if req.SortBy == "" {
+		req.SortBy = db.SongsSortUploaded
+	}
+
+	songs, total, err := s.d.GetSongs(ctx, db.SongsQuery{
+		Name:   req.Name,
+		Artist: req.Artist,
+		Album:  req.Album,
+		SortBy: req.SortBy,
+		Desc:   req.Desc,
+		Offset: req.Offset,
+		Limit:  req.Limit,
+	})
 	if err != nil {
-		s.logger.Error("error getting segment", zap.Error(err), zap.Any("req", req))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error getting songs", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error getting songs")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.Songs = songs
+	resp.Total = total
+	resp.Offset = req.Offset
+	resp.Limit = req.Limit
+	return resp, nil
+}
+
+func (s *Service) Search(ctx context.Context, req structs.SearchReq) (resp structs.SearchResp, err error) {
+	if strings.TrimSpace(req.Query) == "" {
+		err = validationError("query cannot be empty")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+	if req.Limit == 0 {
+		req.Limit = DefaultSongsPageLimit
+	}
+	if req.Limit > db.GetAllSongsLimit {
+		req.Limit = db.GetAllSongsLimit
+	}
+
+	songs, total, err := s.d.SearchSongs(ctx, db.SearchQuery{
+		Query:  req.Query,
+		Offset: req.Offset,
+		Limit:  req.Limit,
+	})
+	if err != nil {
+		s.log(ctx).Error("error searching songs", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error searching songs")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.Songs = songs
+	resp.Total = total
+	resp.Offset = req.Offset
+	resp.Limit = req.Limit
+	return resp, nil
+}
+
+func (s *Service) GetSegment(ctx context.Context, req structs.GetSegmentReq) (resp structs.GetSegmentResp, err error) {
+	segment, modified, err := s.d.GetSegmentInfo(ctx, req.ID)
+	if err != nil {
+		s.log(ctx).Error("error getting segment", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error getting segment")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
 	resp.Segment = segment
+	resp.Modified = modified
 	return resp, err
 }
 
-func (s *Service) NewUser(req globalStructs.User) (resp structs.NewUserResp, err error) {
-	if req.ID == "" {
-		resp.Error = "id cannot be empty"
-		return resp, errors.New(resp.Error)
+// UpdateSong - updates fields of song metadata which are sent, playlists get the new version as well
+func (s *Service) UpdateSong(ctx context.Context, req structs.UpdateSongReq) (resp structs.UpdateSongResp, err error) {
+	if err = s.authorize(ctx, policy.ActionManageSongs); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	u := db.SongUpdate{ID: req.Song.ID, Name: req.Song.Name, Artist: req.Song.Artist, Album: req.Song.Album}
+	if u.Name == nil && u.Artist == nil && u.Album == nil {
+		err = validationError("at least one of name, artist or album is required")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.Playlists, err = s.d.UpdateSong(ctx, u)
+	if err != nil {
+		s.log(ctx).Error("error updating song", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error updating song")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.OK = true
+	return resp, nil
+}
+
+// DeleteSong - removes song with all its segments and removes it from playlists
+func (s *Service) DeleteSong(ctx context.Context, req structs.DeleteSongReq) (resp structs.DeleteSongResp, err error) {
+	if err = s.authorize(ctx, policy.ActionManageSongs); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	legacy, err := s.legacySegments(ctx, req.M3H8)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	result, err := s.d.DeleteSong(ctx, req.SongID, legacy, req.DryRun)
+	if err != nil {
+		s.log(ctx).Error("error deleting song", zap.Error(err), logging.Any("req", req), zap.Bool("partial", result.Partial))
+		err = dbError(err, "error deleting song")
+		resp.ErrorResp = errorResp(err)
+		if result.Partial {
+			resp.Partial = true
+			resp.Segments = result.Segments
+			resp.Playlists = result.Playlists
+			resp.Error += "; song is removed partially, retry delete"
+		}
+		return resp, err
+	}
+
+	resp.DryRun = req.DryRun
+	resp.Segments = result.Segments
+	resp.Playlists = result.Playlists
+	resp.OK = true
+	return resp, nil
+}
+
+// legacySegments - ids of m3h8 and segments it references, db removes only those of them
+// which are not linked to any song
+func (s *Service) legacySegments(ctx context.Context, m3h8 string) ([]string, error) {
+	if m3h8 == "" {
+		return nil, nil
+	}
+
+	segment, err := s.d.GetSegment(ctx, m3h8)
+	if err == db.ErrNotFound {
+		return nil, validationError("m3h8 %s not found", m3h8)
+	}
+	if err != nil {
+		s.log(ctx).Error("error getting m3h8", zap.Error(err), zap.String("id", m3h8))
+		return nil, dbError(err, "error getting m3h8")
+	}
+	if !hls.IsPlaylist(segment.Data) {
+		return nil, validationError("segment %s is not m3h8", m3h8)
+	}
+	return append([]string{m3h8}, hls.SegmentIDs(segment.Data)...), nil
+}
+
+// NewUser - authenticated users may create only the user of their own token, admins may create anyone
+func (s *Service) NewUser(ctx context.Context, req globalStructs.User) (resp structs.NewUserResp, err error) {
+	if err = s.self(ctx, req.ID, policy.ActionManageUsers); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	err = s.d.NewUser(req)
+	err = s.d.NewUser(ctx, req)
 	if err != nil {
-		s.logger.Error("error creating new user", zap.Error(err), zap.Any("req", req))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error creating new user", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error creating new user")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
@@ -103,16 +554,17 @@ func (s *Service) NewUser(req globalStructs.User) (resp structs.NewUserResp, err
 	return resp, nil
 }
 
-func (s *Service) GetUser(req structs.GetUserReq) (resp structs.GetUserResp, err error) {
-	if req.ID == "" {
-		resp.Error = "id cannot be empty"
-		return resp, errors.New(resp.Error)
+func (s *Service) GetUser(ctx context.Context, req structs.GetUserReq) (resp structs.GetUserResp, err error) {
+	if err = s.self(ctx, req.ID, policy.ActionManageUsers); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	u, err := s.d.GetUserByID(req.ID)
+	u, err := s.d.GetUserByID(ctx, req.ID)
 	if err != nil {
-		s.logger.Error("error getting user by id", zap.Error(err), zap.Any("id", req.ID))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error getting user by id", zap.Error(err), logging.Any("id", req.ID))
+		err = dbError(err, "error getting user")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
@@ -120,25 +572,27 @@ func (s *Service) GetUser(req structs.GetUserReq) (resp structs.GetUserResp, err
 	return resp, nil
 }
 
-func (s *Service) NewPlaylist(req structs.NewPlaylistReq) (resp structs.NewPlaylistResp, err error) {
-	if req.UserID == "" || req.PlaylistName == "" {
-		resp.Error = "you need to fill playlist name and user_id"
-		return resp, errors.New(resp.Error)
+func (s *Service) NewPlaylist(ctx context.Context, req structs.NewPlaylistReq) (resp structs.NewPlaylistResp, err error) {
+	owner, err := userID(ctx, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
 	p := globalStructs.Playlist{
 		Name:        req.PlaylistName,
 		Description: req.Description,
-		OwnerID:     req.UserID,
+		OwnerID:     owner,
 		Songs:       []globalStructs.Song{},
 		Created:     time.Now(),
 		Shared:      req.Shared,
 	}
 
-	err = s.d.NewPlaylist(p)
+	err = s.d.NewPlaylist(ctx, p)
 	if err != nil {
-		s.logger.Error("error creating new playlist", zap.Error(err), zap.Any("playlist", p))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error creating new playlist", zap.Error(err), logging.Any("playlist", p))
+		err = dbError(err, "error creating new playlist")
+		resp.ErrorResp = errorResp(err)
 		return
 	}
 
@@ -146,16 +600,22 @@ func (s *Service) NewPlaylist(req structs.NewPlaylistReq) (resp structs.NewPlayl
 	return
 }
 
-func (s *Service) DeletePlaylist(req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error) {
-	if req.PlaylistID == "" || req.UserID == "" {
-		resp.Error = "you need to fill ids"
-		return resp, errors.New(resp.Error)
+func (s *Service) DeletePlaylist(ctx context.Context, req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error) {
+	owner, err := s.playlistOwner(ctx, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	err = s.d.DeleteUserPlaylist(req.PlaylistID, req.UserID)
+	if owner == "" {
+		err = s.d.DeletePlaylistByID(ctx, req.PlaylistID)
+	} else {
+		err = s.d.DeleteUserPlaylist(ctx, req.PlaylistID, owner)
+	}
 	if err != nil {
-		s.logger.Error("error deleting user playlist", zap.Error(err), zap.Any("req", req))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error deleting user playlist", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error deleting playlist")
+		resp.ErrorResp = errorResp(err)
 		return
 	}
 
@@ -164,47 +624,60 @@ func (s *Service) DeletePlaylist(req structs.DeleteUserPlaylistReq) (resp struct
 
 }
 
-func (s *Service) GetUserPlaylist(req structs.GetPlaylistReq) (resp structs.GetPlaylistResp, err error) {
-	if req.PlaylistID == "" {
-		resp.Error = "ids must not be empty"
-		return resp, errors.New(resp.Error)
+// GetUserPlaylist - authenticated users may get their own and shared playlists, admins may get any
+func (s *Service) GetUserPlaylist(ctx context.Context, req structs.GetPlaylistReq) (resp structs.GetPlaylistResp, err error) {
+	p, err := s.d.GetPlaylistByID(ctx, req.PlaylistID)
+	if err != nil {
+		s.log(ctx).Error("error getting user playlist by id", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error getting playlist")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	resp.Playlist, err = s.d.GetPlaylistByID(req.PlaylistID)
-	if err != nil {
-		s.logger.Error("error getting user playlist by id", zap.Error(err), zap.Any("req", req))
-		resp.Error = err.Error()
+	if !p.Shared {
+		if err = s.self(ctx, p.OwnerID, policy.ActionModeratePlaylist); err != nil {
+			resp.ErrorResp = errorResp(err)
+			return resp, err
+		}
 	}
 
-	return
+	resp.Playlist = p
+	return resp, nil
 }
 
-func (s *Service) GetUserPlaylists(req structs.GetUserAllPlaylistsReq) (resp structs.GetUserAllPlaylistsResp, err error) {
-	if req.UserID == "" {
-		resp.Error = "ids must not be empty"
-		return resp, errors.New(resp.Error)
+func (s *Service) GetUserPlaylists(ctx context.Context, req structs.GetUserAllPlaylistsReq) (resp structs.GetUserAllPlaylistsResp, err error) {
+	if err = s.self(ctx, req.UserID, policy.ActionModeratePlaylist); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	resp.Playlists, err = s.d.GetAllUserPlaylists(req.UserID)
+	resp.Playlists, err = s.d.GetAllUserPlaylists(ctx, req.UserID)
 	if err != nil {
-		s.logger.Error("error getting all user playlists")
-		resp.Error = err.Error()
+		s.log(ctx).Error("error getting all user playlists", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error getting user playlists")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
 	return
 }
 
-func (s *Service) DeleteUserPlaylist(req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error) {
-	if req.PlaylistID == "" || req.UserID == "" {
-		resp.Error = "ids must not be empty"
-		return resp, errors.New(resp.Error)
+func (s *Service) DeleteUserPlaylist(ctx context.Context, req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error) {
+	owner, err := s.playlistOwner(ctx, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	err = s.d.DeleteUserPlaylist(req.PlaylistID, req.UserID)
+	if owner == "" {
+		err = s.d.DeletePlaylistByID(ctx, req.PlaylistID)
+	} else {
+		err = s.d.DeleteUserPlaylist(ctx, req.PlaylistID, owner)
+	}
 	if err != nil {
-		s.logger.Error("error deleting user playlist", zap.Error(err), zap.Any("req", req))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error deleting user playlist", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error deleting playlist")
+		resp.ErrorResp = errorResp(err)
 		return
 	}
 
@@ -212,22 +685,30 @@ func (s *Service) DeleteUserPlaylist(req structs.DeleteUserPlaylistReq) (resp st
 	return
 }
 
-func (s *Service) AddSongToUserPlaylist(req structs.AddSongToUserPlaylistReq) (resp structs.AddSongToUserPlaylistResp, err error) {
-	if req.PlaylistID == "" || req.SongID == "" || req.UserID == "" {
-		return
+func (s *Service) AddSongToUserPlaylist(ctx context.Context, req structs.AddSongToUserPlaylistReq) (resp structs.AddSongToUserPlaylistResp, err error) {
+	owner, err := s.playlistOwner(ctx, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	song, err := s.d.GetSongByID(req.SongID)
+	song, err := s.d.GetSongByID(ctx, req.SongID)
 	if err != nil {
-		s.logger.Error("error getting song by id", zap.Error(err))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error getting song by id", zap.Error(err))
+		err = dbError(err, "error getting song")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
-	err = s.d.AddSongsToUserPlaylist(req.PlaylistID, req.UserID, song)
+	if owner == "" {
+		err = s.d.AddSongsToPlaylist(ctx, req.PlaylistID, song)
+	} else {
+		err = s.d.AddSongsToUserPlaylist(ctx, req.PlaylistID, owner, song)
+	}
 	if err != nil {
-		s.logger.Error("error adding song to playlist", zap.Error(err))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error adding song to playlist", zap.Error(err))
+		err = dbError(err, "error adding song to playlist")
+		resp.ErrorResp = errorResp(err)
 		return resp, err
 	}
 
@@ -235,19 +716,49 @@ func (s *Service) AddSongToUserPlaylist(req structs.AddSongToUserPlaylistReq) (r
 	return
 }
 
-func (s *Service) RemoveSongFromUserPlaylist(req structs.RemoveSongFromUserPlaylistReq) (resp structs.RemoveSongFromUserPlaylistResp, err error) {
-	if req.PlaylistID == "" || req.SongID == "" || req.UserID == "" {
-		resp.Error = "ids must not be empty"
-		return
+func (s *Service) RemoveSongFromUserPlaylist(ctx context.Context, req structs.RemoveSongFromUserPlaylistReq) (resp structs.RemoveSongFromUserPlaylistResp, err error) {
+	owner, err := s.playlistOwner(ctx, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
 	}
 
-	err = s.d.RemoveSongFromUserPlaylist(req.PlaylistID, req.UserID, req.SongID)
+	if owner == "" {
+		err = s.d.RemoveSongFromPlaylist(ctx, req.PlaylistID, req.SongID)
+	} else {
+		err = s.d.RemoveSongFromUserPlaylist(ctx, req.PlaylistID, owner, req.SongID)
+	}
 	if err != nil {
-		s.logger.Error("error removing song from playlist", zap.Error(err))
-		resp.Error = err.Error()
+		s.log(ctx).Error("error removing song from playlist", zap.Error(err))
+		err = dbError(err, "error removing song from playlist")
+		resp.ErrorResp = errorResp(err)
 		return
 	}
 
 	resp.OK = true
 	return
 }
+
+// SetUserRoles - replaces stored roles of the user, only admins can do it
+func (s *Service) SetUserRoles(ctx context.Context, req structs.SetUserRolesReq) (resp structs.SetUserRolesResp, err error) {
+	if err = s.authorize(ctx, policy.ActionManageUsers); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	err = s.d.SetUserRoles(ctx, req.UserID, req.Roles)
+	if err != nil {
+		s.log(ctx).Error("error setting user roles", zap.Error(err), logging.Any("req", req))
+		err = dbError(err, "error setting user roles")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.OK = true
+	return resp, nil
+}
+
+// Ready - checks that service can serve requests, i.e. db is reachable
+func (s *Service) Ready(ctx context.Context) error {
+	return s.d.Ping(ctx)
+}
@@ -0,0 +1,1367 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/auth"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/policy"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.uber.org/zap"
+)
+
+// racingDB - hides stored segments from FindSegmentIDs, as if another song inserted them
+// after the request was validated
+type racingDB struct {
+	db.IDB
+}
+
+func (d racingDB) FindSegmentIDs(ctx context.Context, ids ...string) ([]string, error) {
+	return nil, nil
+}
+
+// reversedDB - returns found segments in reverse order, mongo does not keep order of $in
+type reversedDB struct {
+	db.IDB
+}
+
+func (d reversedDB) FindSegmentIDs(ctx context.Context, ids ...string) ([]string, error) {
+	found, err := d.IDB.FindSegmentIDs(ctx, ids...)
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, err
+}
+
+// failingSongDB - fails every InsertSong
+type failingSongDB struct {
+	db.IDB
+}
+
+func (d failingSongDB) InsertSong(ctx context.Context, s globalStructs.Song) error {
+	return errors.New("insert failed")
+}
+
+// failingSegmentsDB - InsertSegment fails segments with ids in failed and writes the others like
+// unordered bulk insert, DeleteSegments fails with deleteErr when it is set
+type failingSegmentsDB struct {
+	db.IDB
+	failed    map[string]error
+	deleteErr error
+}
+
+func (d failingSegmentsDB) InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) error {
+	var result db.InsertSegmentsError
+	for i, v := range ts {
+		if err, ok := d.failed[v.ID]; ok {
+			result.Failed = append(result.Failed, db.SegmentError{Index: i, ID: v.ID, Err: err})
+			continue
+		}
+		if err := d.IDB.InsertSegment(ctx, songID, v); err != nil {
+			return err
+		}
+		result.Inserted++
+	}
+	if len(result.Failed) != 0 {
+		return &result
+	}
+	return nil
+}
+
+func (d failingSegmentsDB) DeleteSegments(ctx context.Context, songID string, ids ...string) error {
+	if d.deleteErr != nil {
+		return d.deleteErr
+	}
+	return d.IDB.DeleteSegments(ctx, songID, ids...)
+}
+
+func segment(id string) globalStructs.SongData {
+	return globalStructs.SongData{ID: id, Data: []byte(id)}
+}
+
+func addSegmentsReq(songID, m3h8 string, ts ...string) structs.AddSegmentsReq {
+	req := structs.AddSegmentsReq{
+		M3H8:     segment(m3h8),
+		SongData: globalStructs.Song{ID: songID, Name: "name", Artist: "artist", Album: "album"},
+	}
+	for _, id := range ts {
+		req.Ts = append(req.Ts, segment(id))
+	}
+	return req
+}
+
+func TestNewSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		// wrap - decorates memory db the service uses, nil uses it as is
+		wrap func(db.IDB) db.IDB
+		// stored - song ingested before the request, it owns segments other-m3h8 and other-ts
+		stored         bool
+		req            structs.AddSegmentsReq
+		code           string
+		failedPart     string
+		failedSegments []string
+		// rollbackFailed - error in response tells that written segments could not be removed
+		rollbackFailed bool
+		// segments - ids which must exist after the call, missing - ids which must not
+		segments []string
+		missing  []string
+		song     bool
+	}{
+		{
+			name:     "ok",
+			req:      addSegmentsReq("song", "m3h8", "ts0", "ts1"),
+			segments: []string{"m3h8", "ts0", "ts1"},
+			song:     true,
+		},
+		{
+			name:       "duplicate id in request",
+			req:        addSegmentsReq("song", "m3h8", "ts0", "ts0"),
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartTs,
+			missing:    []string{"m3h8", "ts0"},
+		},
+		{
+			name:       "song exists",
+			stored:     true,
+			req:        addSegmentsReq("other", "m3h8", "ts0"),
+			code:       structs.ErrCodeConflict,
+			failedPart: structs.IngestPartSongData,
+			missing:    []string{"m3h8", "ts0"},
+			song:       true,
+		},
+		{
+			name:       "m3h8 exists and is found after ts",
+			wrap:       func(d db.IDB) db.IDB { return reversedDB{d} },
+			stored:     true,
+			req:        addSegmentsReq("song", "other-m3h8", "other-ts"),
+			code:       structs.ErrCodeConflict,
+			failedPart: structs.IngestPartM3H8,
+			segments:   []string{"other-m3h8", "other-ts"},
+		},
+		{
+			name:           "ts inserted by another song meanwhile",
+			wrap:           func(d db.IDB) db.IDB { return racingDB{d} },
+			stored:         true,
+			req:            addSegmentsReq("song", "m3h8", "ts0", "other-ts", "ts2"),
+			code:           structs.ErrCodeConflict,
+			failedPart:     structs.IngestPartTs,
+			failedSegments: []string{"other-ts"},
+			segments:       []string{"other-m3h8", "other-ts"},
+			missing:        []string{"m3h8", "ts0", "ts2"},
+		},
+		{
+			name: "some ts fail, written ones are rolled back",
+			wrap: func(d db.IDB) db.IDB {
+				return failingSegmentsDB{IDB: d, failed: map[string]error{"ts1": errors.New("write failed")}}
+			},
+			req:            addSegmentsReq("song", "m3h8", "ts0", "ts1", "ts2"),
+			code:           structs.ErrCodeInternal,
+			failedPart:     structs.IngestPartTs,
+			failedSegments: []string{"ts1"},
+			missing:        []string{"m3h8", "ts0", "ts1", "ts2"},
+		},
+		{
+			name: "all failed ts are duplicates",
+			wrap: func(d db.IDB) db.IDB {
+				return failingSegmentsDB{IDB: d, failed: map[string]error{"ts0": db.ErrDuplicate, "ts2": db.ErrDuplicate}}
+			},
+			req:            addSegmentsReq("song", "m3h8", "ts0", "ts1", "ts2"),
+			code:           structs.ErrCodeConflict,
+			failedPart:     structs.IngestPartTs,
+			failedSegments: []string{"ts0", "ts2"},
+			missing:        []string{"m3h8", "ts0", "ts1", "ts2"},
+		},
+		{
+			name: "m3h8 fails",
+			wrap: func(d db.IDB) db.IDB {
+				return failingSegmentsDB{IDB: d, failed: map[string]error{"m3h8": errors.New("write failed")}}
+			},
+			req:            addSegmentsReq("song", "m3h8", "ts0"),
+			code:           structs.ErrCodeInternal,
+			failedPart:     structs.IngestPartM3H8,
+			failedSegments: []string{"m3h8"},
+			missing:        []string{"m3h8", "ts0"},
+		},
+		{
+			name: "rollback fails",
+			wrap: func(d db.IDB) db.IDB {
+				return failingSegmentsDB{IDB: d, failed: map[string]error{"ts1": errors.New("write failed")}, deleteErr: errors.New("delete failed")}
+			},
+			req:            addSegmentsReq("song", "m3h8", "ts0", "ts1"),
+			code:           structs.ErrCodeInternal,
+			failedPart:     structs.IngestPartTs,
+			failedSegments: []string{"ts1"},
+			rollbackFailed: true,
+			segments:       []string{"m3h8", "ts0"},
+			missing:        []string{"ts1"},
+		},
+		{
+			name:       "song insert fails",
+			wrap:       func(d db.IDB) db.IDB { return failingSongDB{d} },
+			req:        addSegmentsReq("song", "m3h8", "ts0", "ts1"),
+			code:       structs.ErrCodeInternal,
+			failedPart: structs.IngestPartSongData,
+			missing:    []string{"m3h8", "ts0", "ts1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			if tt.stored {
+				other := addSegmentsReq("other", "other-m3h8", "other-ts")
+				if err := mem.InsertSegment(ctx, "other", other.M3H8, other.Ts[0]); err != nil {
+					t.Fatal(err)
+				}
+				if err := mem.InsertSong(ctx, other.SongData); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := mem
+			if tt.wrap != nil {
+				d = tt.wrap(mem)
+			}
+			s := NewService(d, policy.New(nil), config.Upload{}, zap.NewNop())
+
+			resp, err := s.NewSegments(ctx, tt.req)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if Code(err) != tt.code && tt.code != "" {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if resp.OK != (tt.code == "") {
+				t.Errorf("ok = %v", resp.OK)
+			}
+			if resp.FailedPart != tt.failedPart {
+				t.Errorf("failed part = %q, want %q", resp.FailedPart, tt.failedPart)
+			}
+			if len(resp.FailedSegments) != 0 || len(tt.failedSegments) != 0 {
+				if !reflect.DeepEqual(resp.FailedSegments, tt.failedSegments) {
+					t.Errorf("failed segments = %q, want %q", resp.FailedSegments, tt.failedSegments)
+				}
+			}
+			if got := strings.HasSuffix(resp.Error, "; rollback failed"); got != tt.rollbackFailed {
+				t.Errorf("error %q tells rollback failed = %v, want %v", resp.Error, got, tt.rollbackFailed)
+			}
+
+			for _, id := range tt.segments {
+				if _, err := mem.GetSegment(ctx, id); err != nil {
+					t.Errorf("segment %s: %s", id, err)
+				}
+			}
+			for _, id := range tt.missing {
+				if _, err := mem.GetSegment(ctx, id); err != db.ErrNotFound {
+					t.Errorf("segment %s: got %v, want not found", id, err)
+				}
+			}
+			_, err = mem.GetSongByID(ctx, tt.req.SongData.ID)
+			if tt.song != (err == nil) {
+				t.Errorf("song %s stored = %v, want %v", tt.req.SongData.ID, err == nil, tt.song)
+			}
+		})
+	}
+}
+
+func TestDeleteSong(t *testing.T) {
+	m3h8 := globalStructs.SongData{ID: "legacy-m3h8", Data: []byte("#EXTM3U\n#EXTINF:10,\nlegacy-ts0\n#EXTINF:10,\n/segments/other-ts?x=1\n")}
+	tests := []struct {
+		name string
+		req  structs.DeleteSongReq
+		code string
+		// segments - ids in response, kept - ids which must exist after the call, missing - ids which must not
+		segments []string
+		kept     []string
+		missing  []string
+		song     bool
+	}{
+		{
+			name:     "linked segments only",
+			req:      structs.DeleteSongReq{SongID: "song"},
+			segments: []string{"ts0"},
+			kept:     []string{"legacy-m3h8", "legacy-ts0", "other-ts"},
+			missing:  []string{"ts0"},
+		},
+		{
+			name:     "legacy segments through m3h8, segments of other songs are kept",
+			req:      structs.DeleteSongReq{SongID: "song", M3H8: "legacy-m3h8"},
+			segments: []string{"legacy-m3h8", "legacy-ts0", "ts0"},
+			kept:     []string{"other-ts"},
+			missing:  []string{"legacy-m3h8", "legacy-ts0", "ts0"},
+		},
+		{
+			name:     "dry run",
+			req:      structs.DeleteSongReq{SongID: "song", M3H8: "legacy-m3h8", DryRun: true},
+			segments: []string{"legacy-m3h8", "legacy-ts0", "ts0"},
+			kept:     []string{"legacy-m3h8", "legacy-ts0", "ts0", "other-ts"},
+			song:     true,
+		},
+		{
+			name: "m3h8 is not a playlist",
+			req:  structs.DeleteSongReq{SongID: "song", M3H8: "ts0"},
+			code: structs.ErrCodeValidation,
+			kept: []string{"legacy-m3h8", "legacy-ts0", "ts0"},
+			song: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			// segments stored before they were linked to their song have no song id
+			if err := mem.InsertSegment(ctx, "", m3h8, segment("legacy-ts0")); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.InsertSegment(ctx, "song", segment("ts0")); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.InsertSegment(ctx, "other", segment("other-ts")); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.InsertSong(ctx, globalStructs.Song{ID: "song", Name: "name"}); err != nil {
+				t.Fatal(err)
+			}
+			s := NewService(mem, policy.New(nil), config.Upload{}, zap.NewNop())
+
+			resp, err := s.DeleteSong(ctx, tt.req)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if resp.OK != (tt.code == "") {
+				t.Errorf("ok = %v", resp.OK)
+			}
+			got := append([]string(nil), resp.Segments...)
+			sort.Strings(got)
+			if len(got) != 0 || len(tt.segments) != 0 {
+				if !reflect.DeepEqual(got, tt.segments) {
+					t.Errorf("segments = %q, want %q", got, tt.segments)
+				}
+			}
+			for _, id := range tt.kept {
+				if _, err := mem.GetSegment(ctx, id); err != nil {
+					t.Errorf("segment %s: %s", id, err)
+				}
+			}
+			for _, id := range tt.missing {
+				if _, err := mem.GetSegment(ctx, id); err != db.ErrNotFound {
+					t.Errorf("segment %s: got %v, want not found", id, err)
+				}
+			}
+			_, err = mem.GetSongByID(ctx, "song")
+			if tt.song != (err == nil) {
+				t.Errorf("song stored = %v, want %v", err == nil, tt.song)
+			}
+		})
+	}
+}
+
+func TestUpdateSong(t *testing.T) {
+	name, empty := "new name", ""
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  structs.UpdateSongReq
+		code string
+		want globalStructs.Song
+		// playlists - number of playlists with changed copy of the song
+		playlists int
+	}{
+		{
+			name:      "fields which are not sent are kept",
+			ctx:       asUser("uploader"),
+			req:       structs.UpdateSongReq{Song: structs.SongUpdate{ID: "song", Name: &name}},
+			want:      globalStructs.Song{ID: "song", Name: "new name", Artist: "artist", Album: "album"},
+			playlists: 1,
+		},
+		{
+			name:      "empty value clears field",
+			ctx:       context.Background(),
+			req:       structs.UpdateSongReq{Song: structs.SongUpdate{ID: "song", Album: &empty}},
+			want:      globalStructs.Song{ID: "song", Name: "name", Artist: "artist"},
+			playlists: 1,
+		},
+		{
+			name: "empty update",
+			ctx:  asUser("uploader"),
+			req:  structs.UpdateSongReq{Song: structs.SongUpdate{ID: "song"}},
+			code: structs.ErrCodeValidation,
+			want: globalStructs.Song{ID: "song", Name: "name", Artist: "artist", Album: "album"},
+		},
+		{
+			name: "missing song",
+			ctx:  asUser("uploader"),
+			req:  structs.UpdateSongReq{Song: structs.SongUpdate{ID: "missing", Name: &name}},
+			code: structs.ErrCodeNotFound,
+			want: globalStructs.Song{ID: "song", Name: "name", Artist: "artist", Album: "album"},
+		},
+		{
+			name: "listener",
+			ctx:  asUser("listener"),
+			req:  structs.UpdateSongReq{Song: structs.SongUpdate{ID: "song", Name: &name}},
+			code: structs.ErrCodeForbidden,
+			want: globalStructs.Song{ID: "song", Name: "name", Artist: "artist", Album: "album"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			song := globalStructs.Song{ID: "song", Name: "name", Artist: "artist", Album: "album"}
+			if err := mem.InsertSong(ctx, song); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.NewUser(ctx, globalStructs.User{ID: "uploader"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.SetUserRoles(ctx, "uploader", []string{policy.RoleUploader}); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.NewPlaylist(ctx, globalStructs.Playlist{Name: "mine", OwnerID: "alice", Songs: []globalStructs.Song{song}}); err != nil {
+				t.Fatal(err)
+			}
+			s := NewService(mem, policy.New(nil), config.Upload{}, zap.NewNop())
+
+			resp, err := s.UpdateSong(tt.ctx, tt.req)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if resp.OK != (tt.code == "") || resp.Playlists != tt.playlists {
+				t.Errorf("resp = %+v", resp)
+			}
+
+			if got, err := mem.GetSongByID(ctx, "song"); err != nil || got != tt.want {
+				t.Errorf("song = %+v, %v, want %+v", got, err, tt.want)
+			}
+			playlists, err := mem.GetAllUserPlaylists(ctx, "alice")
+			if err != nil || len(playlists) != 1 {
+				t.Fatalf("playlists = %+v, %v", playlists, err)
+			}
+			p, err := mem.GetPlaylistByID(ctx, playlists[0].ID)
+			if err != nil || len(p.Songs) != 1 || p.Songs[0] != tt.want {
+				t.Errorf("songs of playlist = %+v, %v, want %+v", p.Songs, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSongs(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    structs.GetSongsReq
+		want   []string
+		total  int
+		offset int
+		limit  int
+	}{
+		{
+			name:  "default page sorted by upload",
+			req:   structs.GetSongsReq{},
+			want:  []string{"a", "b", "c"},
+			total: 3,
+			limit: DefaultSongsPageLimit,
+		},
+		{
+			name:  "ties of name are ordered by id",
+			req:   structs.GetSongsReq{SortBy: db.SongsSortName, Limit: 2},
+			want:  []string{"a", "c"},
+			total: 3,
+			limit: 2,
+		},
+		{
+			name:   "offset past the end",
+			req:    structs.GetSongsReq{Offset: 5},
+			total:  3,
+			offset: 5,
+			limit:  DefaultSongsPageLimit,
+		},
+		{
+			name:  "limit is capped",
+			req:   structs.GetSongsReq{Limit: db.GetAllSongsLimit + 1},
+			want:  []string{"a", "b", "c"},
+			total: 3,
+			limit: db.GetAllSongsLimit,
+		},
+	}
+
+	ctx := context.Background()
+	mem := db.NewMemoryDB(zap.NewNop())
+	for _, song := range []globalStructs.Song{{ID: "a", Name: "same"}, {ID: "b", Name: "z"}, {ID: "c", Name: "same"}} {
+		if err := mem.InsertSong(ctx, song); err != nil {
+			t.Fatal(err)
+		}
+		// upload times must differ for the default order to be the order of inserts
+		time.Sleep(time.Millisecond)
+	}
+	s := NewService(mem, policy.New(nil), config.Upload{}, zap.NewNop())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.GetSongs(ctx, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, song := range resp.Songs {
+				ids = append(ids, song.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) || resp.Total != tt.total || resp.Offset != tt.offset || resp.Limit != tt.limit {
+				t.Errorf("songs = %v, total %d, offset %d, limit %d, want %v, %d, %d, %d",
+					ids, resp.Total, resp.Offset, resp.Limit, tt.want, tt.total, tt.offset, tt.limit)
+			}
+		})
+	}
+}
+
+// asUser - context of request authenticated as user id
+func asUser(id string) context.Context {
+	return auth.NewContext(context.Background(), auth.Principal{UserID: id})
+}
+
+func TestPlaylists(t *testing.T) {
+	const owner, other, admin = "alice", "bob", "admin"
+	tests := []struct {
+		name string
+		ctx  context.Context
+		// call - playlist is id of playlist owner created before the call, it has song "song"
+		call func(t *testing.T, ctx context.Context, s IService, playlist string) error
+		code string
+		// deleted - playlist must not exist after the call, songs - its songs otherwise
+		deleted bool
+		songs   []string
+		// others - number of playlists of other user after the call
+		others int
+	}{
+		{
+			name: "new playlist belongs to caller",
+			ctx:  asUser(other),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.NewPlaylist(ctx, structs.NewPlaylistReq{PlaylistName: "mine"})
+				return err
+			},
+			songs:  []string{"song"},
+			others: 1,
+		},
+		{
+			name: "new playlist for another user",
+			ctx:  asUser(other),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.NewPlaylist(ctx, structs.NewPlaylistReq{UserID: owner, PlaylistName: "mine"})
+				return err
+			},
+			code:  structs.ErrCodeForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "new playlist without auth uses user_id",
+			ctx:  context.Background(),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.NewPlaylist(ctx, structs.NewPlaylistReq{UserID: other, PlaylistName: "mine"})
+				return err
+			},
+			songs:  []string{"song"},
+			others: 1,
+		},
+		{
+			name: "owner deletes playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.DeleteUserPlaylist(ctx, structs.DeleteUserPlaylistReq{PlaylistID: playlist})
+				return err
+			},
+			deleted: true,
+		},
+		{
+			name: "other user deletes playlist",
+			ctx:  asUser(other),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.DeleteUserPlaylist(ctx, structs.DeleteUserPlaylistReq{PlaylistID: playlist})
+				return err
+			},
+			code:  structs.ErrCodeForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "other user_id deletes playlist without auth",
+			ctx:  context.Background(),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.DeleteUserPlaylist(ctx, structs.DeleteUserPlaylistReq{UserID: other, PlaylistID: playlist})
+				return err
+			},
+			code:  structs.ErrCodeForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "admin deletes playlist of another user",
+			ctx:  asUser(admin),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.DeleteUserPlaylist(ctx, structs.DeleteUserPlaylistReq{PlaylistID: playlist})
+				return err
+			},
+			deleted: true,
+		},
+		{
+			name: "delete missing playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.DeleteUserPlaylist(ctx, structs.DeleteUserPlaylistReq{PlaylistID: "missing"})
+				return err
+			},
+			code:  structs.ErrCodeNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "get all playlists of user",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				resp, err := s.GetUserPlaylists(ctx, structs.GetUserAllPlaylistsReq{UserID: owner})
+				if len(resp.Playlists) != 1 || resp.Playlists[0].ID != playlist || resp.Playlists[0].OwnerID != owner {
+					t.Errorf("playlists = %+v", resp.Playlists)
+				}
+				return err
+			},
+			songs: []string{"song"},
+		},
+		{
+			name: "other user gets all playlists of user",
+			ctx:  asUser(other),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.GetUserPlaylists(ctx, structs.GetUserAllPlaylistsReq{UserID: owner})
+				return err
+			},
+			code:  structs.ErrCodeForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "admin gets all playlists of user",
+			ctx:  asUser(admin),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				resp, err := s.GetUserPlaylists(ctx, structs.GetUserAllPlaylistsReq{UserID: owner})
+				if len(resp.Playlists) != 1 {
+					t.Errorf("playlists = %+v", resp.Playlists)
+				}
+				return err
+			},
+			songs: []string{"song"},
+		},
+		{
+			name: "get playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				resp, err := s.GetUserPlaylist(ctx, structs.GetPlaylistReq{PlaylistID: playlist})
+				if resp.Playlist.ID != playlist || resp.Playlist.OwnerID != owner {
+					t.Errorf("playlist = %+v", resp.Playlist)
+				}
+				return err
+			},
+			songs: []string{"song"},
+		},
+		{
+			name: "get missing playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.GetUserPlaylist(ctx, structs.GetPlaylistReq{PlaylistID: "missing"})
+				return err
+			},
+			code:  structs.ErrCodeNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "owner adds song",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.AddSongToUserPlaylist(ctx, structs.AddSongToUserPlaylistReq{PlaylistID: playlist, SongID: "song2"})
+				return err
+			},
+			songs: []string{"song", "song2"},
+		},
+		{
+			name: "other user adds song",
+			ctx:  asUser(other),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.AddSongToUserPlaylist(ctx, structs.AddSongToUserPlaylistReq{PlaylistID: playlist, SongID: "song2"})
+				return err
+			},
+			code:  structs.ErrCodeForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "add song to missing playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.AddSongToUserPlaylist(ctx, structs.AddSongToUserPlaylistReq{PlaylistID: "missing", SongID: "song2"})
+				return err
+			},
+			code:  structs.ErrCodeNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "add missing song",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.AddSongToUserPlaylist(ctx, structs.AddSongToUserPlaylistReq{PlaylistID: playlist, SongID: "missing"})
+				return err
+			},
+			code:  structs.ErrCodeNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "owner removes song",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.RemoveSongFromUserPlaylist(ctx, structs.RemoveSongFromUserPlaylistReq{PlaylistID: playlist, SongID: "song"})
+				return err
+			},
+			songs: []string{},
+		},
+		{
+			name: "other user removes song",
+			ctx:  asUser(other),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.RemoveSongFromUserPlaylist(ctx, structs.RemoveSongFromUserPlaylistReq{PlaylistID: playlist, SongID: "song"})
+				return err
+			},
+			code:  structs.ErrCodeForbidden,
+			songs: []string{"song"},
+		},
+		{
+			name: "remove song which is not in playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.RemoveSongFromUserPlaylist(ctx, structs.RemoveSongFromUserPlaylistReq{PlaylistID: playlist, SongID: "song2"})
+				return err
+			},
+			code:  structs.ErrCodeNotFound,
+			songs: []string{"song"},
+		},
+		{
+			name: "remove song from missing playlist",
+			ctx:  asUser(owner),
+			call: func(t *testing.T, ctx context.Context, s IService, playlist string) error {
+				_, err := s.RemoveSongFromUserPlaylist(ctx, structs.RemoveSongFromUserPlaylistReq{PlaylistID: "missing", SongID: "song"})
+				return err
+			},
+			code:  structs.ErrCodeNotFound,
+			songs: []string{"song"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			for _, id := range []string{"song", "song2"} {
+				if err := mem.InsertSong(ctx, globalStructs.Song{ID: id, Name: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := NewService(mem, policy.New([]string{admin}), config.Upload{}, zap.NewNop())
+
+			if _, err := s.NewPlaylist(asUser(owner), structs.NewPlaylistReq{PlaylistName: "mix"}); err != nil {
+				t.Fatal(err)
+			}
+			owned, err := mem.GetAllUserPlaylists(ctx, owner)
+			if err != nil || len(owned) != 1 {
+				t.Fatalf("playlists of owner = %+v, %v", owned, err)
+			}
+			playlist := owned[0].ID
+			if _, err := s.AddSongToUserPlaylist(asUser(owner), structs.AddSongToUserPlaylistReq{PlaylistID: playlist, SongID: "song"}); err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.call(t, tt.ctx, s, playlist)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+
+			p, err := mem.GetPlaylistByID(ctx, playlist)
+			if tt.deleted {
+				if err != db.ErrNotFound {
+					t.Errorf("playlist: got %v, want not found", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("playlist: %s", err)
+				}
+				if p.OwnerID != owner {
+					t.Errorf("owner = %q, want %q", p.OwnerID, owner)
+				}
+				songs := []string{}
+				for _, v := range p.Songs {
+					songs = append(songs, v.ID)
+				}
+				if !reflect.DeepEqual(songs, tt.songs) {
+					t.Errorf("songs = %q, want %q", songs, tt.songs)
+				}
+			}
+
+			for user, want := range map[string]int{owner: 0, other: tt.others} {
+				if user == owner && !tt.deleted {
+					want = 1
+				}
+				playlists, err := mem.GetAllUserPlaylists(ctx, user)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(playlists) != want {
+					t.Errorf("%s has %d playlists, want %d", user, len(playlists), want)
+				}
+				for _, v := range playlists {
+					if v.OwnerID != user {
+						t.Errorf("playlist %s of %s has owner %q", v.ID, user, v.OwnerID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		id   string
+		code string
+	}{
+		{name: "self", ctx: asUser("alice"), id: "alice"},
+		{name: "other user", ctx: asUser("bob"), id: "alice", code: structs.ErrCodeForbidden},
+		{name: "admin", ctx: asUser("admin"), id: "alice"},
+		{name: "service", ctx: auth.NewContext(context.Background(), auth.Principal{Service: "web"}), id: "alice"},
+		{name: "missing user", ctx: asUser("admin"), id: "missing", code: structs.ErrCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := db.NewMemoryDB(zap.NewNop())
+			if err := mem.NewUser(context.Background(), globalStructs.User{ID: "alice", Username: "alice"}); err != nil {
+				t.Fatal(err)
+			}
+			s := NewService(mem, policy.New([]string{"admin"}), config.Upload{}, zap.NewNop())
+
+			resp, err := s.GetUser(tt.ctx, structs.GetUserReq{ID: tt.id})
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if resp.User.ID != tt.id {
+					t.Errorf("user = %+v", resp.User)
+				}
+				return
+			}
+			if Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if resp.User.ID != "" {
+				t.Errorf("user %s is returned", resp.User.ID)
+			}
+		})
+	}
+}
+
+// concurrentUploadDB - before each segment insert uploads segment id under key of session, as if
+// another request of the same session got there first. id equal to the id of the request is a
+// retry running at the same time, another id is a competing upload of the same part
+type concurrentUploadDB struct {
+	db.IDB
+	session, key, id string
+}
+
+func (d *concurrentUploadDB) InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) error {
+	if d.session != "" {
+		if err := d.IDB.InsertSegment(ctx, songID, segment(d.id)); err != nil {
+			return err
+		}
+		if err := d.IDB.SetUploadSegment(ctx, d.session, d.key, d.id, time.Now().Add(time.Hour)); err != nil {
+			return err
+		}
+	}
+	return d.IDB.InsertSegment(ctx, songID, ts...)
+}
+
+func TestCreateUpload(t *testing.T) {
+	if err := validation.Register(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		tsCount int
+		songID  string
+		// invalid - request is rejected by binding before it gets to the service
+		invalid bool
+		code    string
+	}{
+		{name: "ok", tsCount: 2, songID: "song"},
+		{name: "no ts", tsCount: 0, songID: "song", invalid: true},
+		{name: "too many ts", tsCount: 2001, songID: "song", invalid: true},
+		{name: "song exists", tsCount: 2, songID: "stored", code: structs.ErrCodeConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			if err := mem.InsertSong(ctx, globalStructs.Song{ID: "stored", Name: "name"}); err != nil {
+				t.Fatal(err)
+			}
+			s := NewService(mem, policy.New(nil), config.Upload{SessionTTL: time.Hour}, zap.NewNop())
+
+			req := structs.CreateUploadReq{SongData: globalStructs.Song{ID: tt.songID, Name: "name"}, TsCount: tt.tsCount}
+			if err := validation.Struct(req); (err != nil) != tt.invalid {
+				t.Fatalf("validation error = %v, want invalid %v", err, tt.invalid)
+			}
+			if tt.invalid {
+				return
+			}
+
+			resp, err := s.CreateUpload(ctx, req)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" {
+				if Code(err) != tt.code {
+					t.Errorf("code = %s, want %s", Code(err), tt.code)
+				}
+				return
+			}
+			if resp.Session.TsCount != tt.tsCount || len(resp.Session.Missing) != tt.tsCount || resp.Session.M3H8 != "" {
+				t.Errorf("session = %+v", resp.Session)
+			}
+			if _, err := mem.GetUploadSession(ctx, resp.Session.ID); err != nil {
+				t.Errorf("session is not stored: %s", err)
+			}
+		})
+	}
+}
+
+func TestUploadSegment(t *testing.T) {
+	type upload struct {
+		part string
+		n    int
+		id   string
+	}
+	tests := []struct {
+		name string
+		// before - uploads done before the tested one, they must succeed
+		before []upload
+		// orphan - segment stored for song songID without being recorded, as if upload stopped between insert and record
+		orphan, songID string
+		// concurrent - upload of ts 0 which another request records right before the tested insert
+		concurrent string
+		upload     upload
+		code       string
+		// recorded - segments of session by key after the call
+		recorded map[string]string
+		segments []string
+		missing  []string
+	}{
+		{
+			name:     "m3h8",
+			upload:   upload{structs.IngestPartM3H8, 0, "m3h8"},
+			recorded: map[string]string{db.UploadKeyM3H8: "m3h8"},
+			segments: []string{"m3h8"},
+		},
+		{
+			name:     "ts is recorded by its index",
+			upload:   upload{structs.IngestPartTs, 1, "ts1"},
+			recorded: map[string]string{"1": "ts1"},
+			segments: []string{"ts1"},
+		},
+		{
+			name:     "ts out of range",
+			upload:   upload{structs.IngestPartTs, 2, "ts2"},
+			code:     structs.ErrCodeValidation,
+			recorded: map[string]string{},
+			missing:  []string{"ts2"},
+		},
+		{
+			name:     "retry of recorded segment",
+			before:   []upload{{structs.IngestPartTs, 0, "ts0"}},
+			upload:   upload{structs.IngestPartTs, 0, "ts0"},
+			recorded: map[string]string{"0": "ts0"},
+			segments: []string{"ts0"},
+		},
+		{
+			name:     "another id for recorded part",
+			before:   []upload{{structs.IngestPartTs, 0, "ts0"}},
+			upload:   upload{structs.IngestPartTs, 0, "other"},
+			code:     structs.ErrCodeConflict,
+			recorded: map[string]string{"0": "ts0"},
+			segments: []string{"ts0"},
+			missing:  []string{"other"},
+		},
+		{
+			name:     "same id for another part",
+			before:   []upload{{structs.IngestPartTs, 0, "ts0"}},
+			upload:   upload{structs.IngestPartTs, 1, "ts0"},
+			code:     structs.ErrCodeValidation,
+			recorded: map[string]string{"0": "ts0"},
+			segments: []string{"ts0"},
+		},
+		{
+			name:     "orphan segment of the song is recorded",
+			orphan:   "ts0",
+			songID:   "song",
+			upload:   upload{structs.IngestPartTs, 0, "ts0"},
+			recorded: map[string]string{"0": "ts0"},
+			segments: []string{"ts0"},
+		},
+		{
+			name:     "segment of another song",
+			orphan:   "ts0",
+			songID:   "other",
+			upload:   upload{structs.IngestPartTs, 0, "ts0"},
+			code:     structs.ErrCodeConflict,
+			recorded: map[string]string{},
+			segments: []string{"ts0"},
+		},
+		{
+			name:       "retry recorded meanwhile",
+			concurrent: "ts0",
+			upload:     upload{structs.IngestPartTs, 0, "ts0"},
+			recorded:   map[string]string{"0": "ts0"},
+			segments:   []string{"ts0"},
+		},
+		{
+			name:       "another id recorded meanwhile",
+			concurrent: "winner",
+			upload:     upload{structs.IngestPartTs, 0, "loser"},
+			code:       structs.ErrCodeConflict,
+			recorded:   map[string]string{"0": "winner"},
+			segments:   []string{"winner"},
+			missing:    []string{"loser"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			d := &concurrentUploadDB{IDB: mem}
+			s := NewService(d, policy.New(nil), config.Upload{SessionTTL: time.Hour}, zap.NewNop())
+
+			created, err := s.CreateUpload(ctx, structs.CreateUploadReq{SongData: globalStructs.Song{ID: "song", Name: "name"}, TsCount: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := created.Session.ID
+			uploadSegment := func(u upload) error {
+				req := structs.UploadSegmentReq{ID: id, N: u.n, SegmentID: u.id}
+				_, err := s.UploadSegment(ctx, req, u.part, segment(u.id))
+				return err
+			}
+			for _, u := range tt.before {
+				if err := uploadSegment(u); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.orphan != "" {
+				if err := mem.InsertSegment(ctx, tt.songID, segment(tt.orphan)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.concurrent != "" {
+				d.session, d.key, d.id = id, "0", tt.concurrent
+			}
+
+			err = uploadSegment(tt.upload)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+
+			session, err := mem.GetUploadSession(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(session.Segments) != 0 || len(tt.recorded) != 0 {
+				if !reflect.DeepEqual(session.Segments, tt.recorded) {
+					t.Errorf("recorded = %v, want %v", session.Segments, tt.recorded)
+				}
+			}
+			for _, id := range tt.segments {
+				if _, err := mem.GetSegment(ctx, id); err != nil {
+					t.Errorf("segment %s: %s", id, err)
+				}
+			}
+			for _, id := range tt.missing {
+				if _, err := mem.GetSegment(ctx, id); err != db.ErrNotFound {
+					t.Errorf("segment %s: got %v, want not found", id, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		// wrap - decorates memory db the service uses, nil uses it as is
+		wrap       func(db.IDB) db.IDB
+		uploaded   []string
+		code       string
+		failedPart string
+		// song, session - song and session are stored after the call, uploaded segments are
+		// kept while either of them is
+		song    bool
+		session bool
+	}{
+		{
+			name:       "m3h8 is missing",
+			uploaded:   []string{"0", "1"},
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartM3H8,
+			session:    true,
+		},
+		{
+			name:       "ts is missing",
+			uploaded:   []string{db.UploadKeyM3H8, "1"},
+			code:       structs.ErrCodeValidation,
+			failedPart: structs.IngestPartTs,
+			session:    true,
+		},
+		{
+			name:     "complete",
+			uploaded: []string{db.UploadKeyM3H8, "0", "1"},
+			song:     true,
+		},
+		{
+			name:       "song insert fails",
+			wrap:       func(d db.IDB) db.IDB { return failingSongDB{d} },
+			uploaded:   []string{db.UploadKeyM3H8, "0", "1"},
+			code:       structs.ErrCodeInternal,
+			failedPart: structs.IngestPartSongData,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			var d db.IDB = mem
+			if tt.wrap != nil {
+				d = tt.wrap(mem)
+			}
+			s := NewService(d, policy.New(nil), config.Upload{SessionTTL: time.Hour}, zap.NewNop())
+
+			created, err := s.CreateUpload(ctx, structs.CreateUploadReq{SongData: globalStructs.Song{ID: "song", Name: "name"}, TsCount: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := created.Session.ID
+			for _, key := range tt.uploaded {
+				req := structs.UploadSegmentReq{ID: id, SegmentID: "seg-" + key}
+				part := structs.IngestPartM3H8
+				if key != db.UploadKeyM3H8 {
+					req.N, _ = strconv.Atoi(key)
+					part = structs.IngestPartTs
+				}
+				if _, err := s.UploadSegment(ctx, req, part, segment(req.SegmentID)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := s.CommitUpload(ctx, structs.UploadSessionReq{ID: id})
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if resp.OK != (tt.code == "") || resp.FailedPart != tt.failedPart {
+				t.Errorf("ok = %v, failed part = %q, want %q", resp.OK, resp.FailedPart, tt.failedPart)
+			}
+
+			_, err = mem.GetSongByID(ctx, "song")
+			if tt.song != (err == nil) {
+				t.Errorf("song stored = %v, want %v", err == nil, tt.song)
+			}
+			_, err = mem.GetUploadSession(ctx, id)
+			if tt.session != (err == nil) {
+				t.Errorf("session stored = %v, want %v", err == nil, tt.session)
+			}
+			for _, key := range tt.uploaded {
+				_, err := mem.GetSegment(ctx, "seg-"+key)
+				if kept := tt.song || tt.session; kept != (err == nil) {
+					t.Errorf("segment seg-%s stored = %v, want %v", key, err == nil, kept)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveUploads(t *testing.T) {
+	abort := func(ctx context.Context, s IService, id string) (int, error) {
+		if _, err := s.AbortUpload(ctx, structs.UploadSessionReq{ID: id}); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	cleanup := func(ctx context.Context, s IService, id string) (int, error) {
+		return s.CleanupUploads(ctx)
+	}
+	tests := []struct {
+		name string
+		// remove - aborts session or runs cleanup, returns number of removed sessions
+		remove func(ctx context.Context, s IService, id string) (int, error)
+		// expired - session expired before remove is called
+		expired bool
+		code    string
+		removed int
+	}{
+		{name: "abort", remove: abort, removed: 1},
+		{name: "abort expired", remove: abort, expired: true, code: structs.ErrCodeNotFound},
+		{name: "cleanup keeps session which did not expire", remove: cleanup},
+		{name: "cleanup removes expired session", remove: cleanup, expired: true, removed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			s := NewService(mem, policy.New(nil), config.Upload{SessionTTL: time.Hour}, zap.NewNop())
+
+			expires := time.Now().Add(time.Hour)
+			if tt.expired {
+				expires = time.Now().Add(-time.Minute)
+			}
+			id, err := mem.NewUploadSession(ctx, db.UploadSession{
+				Song:     globalStructs.Song{ID: "song", Name: "name"},
+				TsCount:  1,
+				Segments: map[string]string{db.UploadKeyM3H8: "m3h8", "0": "ts0"},
+				Expires:  expires,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.InsertSegment(ctx, "song", segment("m3h8"), segment("ts0")); err != nil {
+				t.Fatal(err)
+			}
+
+			removed, err := tt.remove(ctx, s, id)
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if err == nil && removed != tt.removed {
+				t.Errorf("removed = %d, want %d", removed, tt.removed)
+			}
+
+			_, err = mem.GetUploadSession(ctx, id)
+			if gone := tt.removed == 1; gone != (err == db.ErrNotFound) {
+				t.Errorf("session removed = %v, want %v", err == db.ErrNotFound, gone)
+			}
+			for _, seg := range []string{"m3h8", "ts0"} {
+				_, err := mem.GetSegment(ctx, seg)
+				if gone := tt.removed == 1; gone != (err == db.ErrNotFound) {
+					t.Errorf("segment %s removed = %v, want %v", seg, err == db.ErrNotFound, gone)
+				}
+			}
+		})
+	}
+}
+
+func TestGetUserPlaylist(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		shared bool
+		code   string
+	}{
+		{name: "owner gets private playlist", ctx: asUser("alice")},
+		{name: "other user gets private playlist", ctx: asUser("bob"), code: structs.ErrCodeForbidden},
+		{name: "other user gets shared playlist", ctx: asUser("bob"), shared: true},
+		{name: "admin gets private playlist", ctx: asUser("admin")},
+		{name: "service gets private playlist", ctx: auth.NewContext(context.Background(), auth.Principal{Service: "web"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := db.NewMemoryDB(zap.NewNop())
+			s := NewService(mem, policy.New([]string{"admin"}), config.Upload{}, zap.NewNop())
+			if _, err := s.NewPlaylist(asUser("alice"), structs.NewPlaylistReq{PlaylistName: "mix", Shared: tt.shared}); err != nil {
+				t.Fatal(err)
+			}
+			owned, err := mem.GetAllUserPlaylists(ctx, "alice")
+			if err != nil || len(owned) != 1 {
+				t.Fatalf("playlists of owner = %+v, %v", owned, err)
+			}
+
+			resp, err := s.GetUserPlaylist(tt.ctx, structs.GetPlaylistReq{PlaylistID: owned[0].ID})
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if resp.Playlist.ID != owned[0].ID {
+					t.Errorf("playlist = %+v", resp.Playlist)
+				}
+				return
+			}
+			if Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			if resp.Playlist.ID != "" {
+				t.Errorf("playlist %s is returned", resp.Playlist.ID)
+			}
+		})
+	}
+}
+
+func TestNewUser(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		id   string
+		code string
+	}{
+		{name: "user of token", ctx: asUser("alice"), id: "alice"},
+		{name: "another user", ctx: asUser("bob"), id: "alice", code: structs.ErrCodeForbidden},
+		{name: "admin", ctx: asUser("admin"), id: "alice"},
+		{name: "service", ctx: auth.NewContext(context.Background(), auth.Principal{Service: "web"}), id: "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := db.NewMemoryDB(zap.NewNop())
+			s := NewService(mem, policy.New([]string{"admin"}), config.Upload{}, zap.NewNop())
+
+			_, err := s.NewUser(tt.ctx, globalStructs.User{ID: tt.id, Username: tt.id})
+			if tt.code == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.code != "" && Code(err) != tt.code {
+				t.Errorf("code = %s, want %s", Code(err), tt.code)
+			}
+			_, err = mem.GetUserByID(context.Background(), tt.id)
+			if (err == nil) != (tt.code == "") {
+				t.Errorf("user stored = %v, want %v", err == nil, tt.code == "")
+			}
+		})
+	}
+}
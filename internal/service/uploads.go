@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/auth"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/policy"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.uber.org/zap"
+)
+
+// cleanupBatch - number of expired sessions removed by one db query of CleanupUploads
+const cleanupBatch = 100
+
+// resumable uploads: song is created as a session, m3h8 and ts are uploaded one by one in any
+// order and retried independently, commit inserts the song when all of them are stored.
+// Every uploaded segment moves expiry of the session, expired sessions are removed with
+// their segments by CleanupUploads
+
+// CreateUpload - starts upload session of song, song must not exist yet
+func (s *Service) CreateUpload(ctx context.Context, req structs.CreateUploadReq) (resp structs.UploadSessionResp, err error) {
+	if err = s.authorize(ctx, policy.ActionUploadSong); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+	owner, err := uploadOwner(ctx, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	_, err = s.d.GetSongByID(ctx, req.SongData.ID)
+	if err == nil {
+		err = conflictError("song %s already exists", req.SongData.ID)
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+	if err != db.ErrNotFound {
+		s.log(ctx).Error("error getting song by id", zap.Error(err), logging.Any("id", req.SongData.ID))
+		err = dbError(err, "error getting song")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	now := time.Now().UTC()
+	session := db.UploadSession{
+		Owner:    owner,
+		Song:     req.SongData,
+		TsCount:  req.TsCount,
+		Segments: map[string]string{},
+		Created:  now,
+		Expires:  now.Add(s.sessionTTL),
+	}
+	session.ID, err = s.d.NewUploadSession(ctx, session)
+	if err != nil {
+		s.log(ctx).Error("error creating upload session", zap.Error(err), logging.Any("song_data", req.SongData))
+		err = dbError(err, "error creating upload session")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.Session = sessionView(session)
+	return resp, nil
+}
+
+// GetUpload - progress of upload session
+func (s *Service) GetUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.UploadSessionResp, err error) {
+	session, err := s.uploadSession(ctx, req.ID, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.Session = sessionView(session)
+	return resp, nil
+}
+
+// UploadSegment - stores m3h8 (part structs.IngestPartM3H8) or ts number req.N of session. Upload of
+// the same segment id again succeeds without writing anything so clients may retry segments they
+// got no answer for, also when the segment was stored but not recorded. A different id for already
+// uploaded part is a conflict. Of concurrent uploads of the same part with different ids only the
+// first recorded one is kept, the others get a conflict and their segments are removed
+func (s *Service) UploadSegment(ctx context.Context, req structs.UploadSegmentReq, part string, segment globalStructs.SongData) (resp structs.UploadSessionResp, err error) {
+	session, err := s.uploadSession(ctx, req.ID, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	key := db.UploadKeyM3H8
+	if part == structs.IngestPartTs {
+		if req.N >= session.TsCount {
+			err = validationError("ts %d is out of range, session has %d ts", req.N, session.TsCount)
+			resp.ErrorResp = errorResp(err)
+			return resp, err
+		}
+		key = strconv.Itoa(req.N)
+	}
+
+	if stored, ok := session.Segments[key]; ok {
+		if stored != segment.ID {
+			err = conflictError("%s %s is already uploaded as %s", part, key, stored)
+			resp.ErrorResp = errorResp(err)
+			return resp, err
+		}
+		resp.Session = sessionView(session)
+		return resp, nil
+	}
+	for k, id := range session.Segments {
+		if id == segment.ID {
+			err = validationError("segment %s is already uploaded as %s", segment.ID, k)
+			resp.ErrorResp = errorResp(err)
+			return resp, err
+		}
+	}
+
+	inserted, err := s.insertUploadSegment(ctx, session, segment)
+	if err != nil {
+		s.log(ctx).Error("error inserting segment", zap.Error(err), zap.String("part", part), zap.String("id", segment.ID))
+		err = dbError(err, "error inserting "+part)
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	expires := time.Now().UTC().Add(s.sessionTTL)
+	if err = s.d.SetUploadSegment(ctx, session.ID, key, segment.ID, expires); err != nil {
+		// session expired or was aborted meanwhile, or a concurrent upload of the same part with
+		// another id was recorded first. Nothing would remove the segment later, but a segment this
+		// call did not insert may be recorded by the retry which did
+		if errors.Is(err, db.ErrConflict) {
+			err = conflictError("%s %s is already uploaded as another segment", part, key)
+		} else {
+			s.log(ctx).Error("error recording uploaded segment", zap.Error(err), logging.Any("session", session.ID))
+			err = dbError(err, "error recording uploaded "+part)
+		}
+		resp.ErrorResp = errorResp(err)
+		if !inserted {
+			return resp, err
+		}
+		if rbErr := s.rollbackSegments(ctx, session.Song.ID, []string{segment.ID}); rbErr != nil {
+			resp.Error += "; rollback failed"
+		}
+		return resp, err
+	}
+
+	session.Segments[key] = segment.ID
+	session.Expires = expires
+	resp.Session = sessionView(session)
+	return resp, nil
+}
+
+// insertUploadSegment - inserts segment of session, inserted is false when segment of the session
+// song with the same id is already stored. It is left by a retry running at the same time or by an
+// upload which stopped before recording it, either way it is recorded again instead of failing
+func (s *Service) insertUploadSegment(ctx context.Context, session db.UploadSession, segment globalStructs.SongData) (inserted bool, err error) {
+	err = s.d.InsertSegment(ctx, session.Song.ID, segment)
+	if err == nil {
+		return true, nil
+	}
+	if !db.IsDup(err) {
+		return false, err
+	}
+
+	songID, gerr := s.d.GetSegmentSongID(ctx, segment.ID)
+	if gerr != nil && gerr != db.ErrNotFound {
+		return false, gerr
+	}
+	if gerr == db.ErrNotFound || songID != session.Song.ID {
+		return false, err
+	}
+	return false, nil
+}
+
+// CommitUpload - inserts song of complete session, its segments become segments of the song.
+// Session is removed first so abort or cleanup running meanwhile can not remove the segments, see
+// removeUpload. If song can not be inserted after that the segments are rolled back and upload has to start over
+func (s *Service) CommitUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.CommitUploadResp, err error) {
+	session, err := s.uploadSession(ctx, req.ID, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	view := sessionView(session)
+	if view.M3H8 == "" {
+		err = validationError("m3h8 is not uploaded")
+		resp.FailedPart = structs.IngestPartM3H8
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+	if len(view.Missing) != 0 {
+		err = validationError("%d ts are not uploaded, first missing is %d", len(view.Missing), view.Missing[0])
+		resp.FailedPart = structs.IngestPartTs
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	if err = s.d.DeleteUploadSession(ctx, session.ID); err != nil {
+		s.log(ctx).Error("error deleting committed upload session", zap.Error(err), logging.Any("session", session.ID))
+		err = dbError(err, "error deleting upload session")
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	if err = s.d.InsertSong(ctx, session.Song); err != nil {
+		s.log(ctx).Error("error inserting song data", zap.Error(err), logging.Any("song_data", session.Song))
+		err = dbError(err, "error inserting song data")
+		resp.FailedPart = structs.IngestPartSongData
+		resp.ErrorResp = errorResp(err)
+		if rbErr := s.rollbackSegments(ctx, session.Song.ID, session.SegmentIDs()); rbErr != nil {
+			resp.Error += "; rollback failed"
+		}
+		return resp, err
+	}
+
+	resp.OK = true
+	return resp, nil
+}
+
+// AbortUpload - removes session with its segments
+func (s *Service) AbortUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.AbortUploadResp, err error) {
+	session, err := s.uploadSession(ctx, req.ID, req.UserID)
+	if err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	if err = s.removeUpload(ctx, session); err != nil {
+		resp.ErrorResp = errorResp(err)
+		return resp, err
+	}
+
+	resp.OK = true
+	return resp, nil
+}
+
+// CleanupUploads - removes sessions which expired with their segments, returns number of removed sessions
+func (s *Service) CleanupUploads(ctx context.Context) (removed int, err error) {
+	for {
+		sessions, err := s.d.ExpiredUploadSessions(ctx, time.Now().UTC(), cleanupBatch)
+		if err != nil {
+			s.log(ctx).Error("error getting expired upload sessions", zap.Error(err))
+			return removed, dbError(err, "error getting expired upload sessions")
+		}
+
+		for _, session := range sessions {
+			err := s.removeUpload(ctx, session)
+			if errors.Is(err, db.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return removed, err
+			}
+			removed++
+		}
+		if len(sessions) < cleanupBatch {
+			return removed, nil
+		}
+	}
+}
+
+// removeUpload - deletes session and then its segments. Whoever deletes the session owns its segments,
+// so abort, cleanup and commit running at the same time never act on the same segments
+func (s *Service) removeUpload(ctx context.Context, session db.UploadSession) error {
+	err := s.d.DeleteUploadSession(ctx, session.ID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return dbError(err, "upload session is already removed")
+		}
+		s.log(ctx).Error("error deleting upload session", zap.Error(err), logging.Any("session", session.ID))
+		return dbError(err, "error deleting upload session")
+	}
+
+	if len(session.Segments) != 0 {
+		if err := s.rollbackSegments(ctx, session.Song.ID, session.SegmentIDs()); err != nil {
+			return dbError(err, "error deleting segments of upload session")
+		}
+	}
+	return nil
+}
+
+// uploadSession - session of caller, expired sessions are not found even before cleanup removes them
+func (s *Service) uploadSession(ctx context.Context, id, bodyID string) (session db.UploadSession, err error) {
+	if err = s.authorize(ctx, policy.ActionUploadSong); err != nil {
+		return session, err
+	}
+	owner, err := uploadOwner(ctx, bodyID)
+	if err != nil {
+		return session, err
+	}
+
+	session, err = s.d.GetUploadSession(ctx, id)
+	if err == nil && !session.Expires.After(time.Now()) {
+		err = db.ErrNotFound
+	}
+	if err != nil {
+		if err != db.ErrNotFound {
+			s.log(ctx).Error("error getting upload session", zap.Error(err), logging.Any("id", id))
+		}
+		return session, dbError(err, "error getting upload session")
+	}
+	if session.Owner != owner {
+		return session, forbiddenError("upload session belongs to another user")
+	}
+	return session, nil
+}
+
+// uploadOwner - user upload sessions belong to, authenticated users own their sessions, services acting
+// on their own and callers when auth is disabled may pass user_id or leave it empty
+func uploadOwner(ctx context.Context, bodyID string) (string, error) {
+	if p, ok := auth.FromContext(ctx); ok && p.UserID != "" {
+		return userID(ctx, bodyID)
+	}
+	return bodyID, nil
+}
+
+// sessionView - progress of session sent to clients
+func sessionView(session db.UploadSession) structs.UploadSession {
+	v := structs.UploadSession{
+		ID:        session.ID,
+		SongData:  session.Song,
+		TsCount:   session.TsCount,
+		M3H8:      session.Segments[db.UploadKeyM3H8],
+		Missing:   []int{},
+		ExpiresAt: session.Expires,
+	}
+	for i := 0; i < session.TsCount; i++ {
+		if _, ok := session.Segments[strconv.Itoa(i)]; ok {
+			v.Received++
+		} else {
+			v.Missing = append(v.Missing, i)
+		}
+	}
+	return v
+}
@@ -0,0 +1,247 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// db operations of spans
+const (
+	opFind   = "find"
+	opInsert = "insert"
+	opUpdate = "update"
+	opDelete = "delete"
+	opPing   = "ping"
+)
+
+// DB - IDB decorator which starts a client span for every call with db system,
+// collection and operation attributes
+type DB struct {
+	d      db.IDB
+	t      trace.Tracer
+	system string
+	c      config.Collections
+}
+
+// NewDB - wraps d, system is db.system attribute and c are collection names of spans
+func NewDB(d db.IDB, t trace.Tracer, system string, c config.Collections) *DB {
+	return &DB{d: d, t: t, system: system, c: c}
+}
+
+func (d *DB) start(ctx context.Context, method, collection, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemKey.String(d.system), semconv.DBOperation(operation))
+	if collection != "" {
+		attrs = append(attrs, semconv.DBMongoDBCollection(collection))
+	}
+	return d.t.Start(ctx, "IDB."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func (d *DB) GetAllSongs(ctx context.Context) (result []globalStructs.Song, err error) {
+	ctx, span := d.start(ctx, "GetAllSongs", d.c.Songs, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetAllSongs(ctx)
+}
+
+func (d *DB) GetSongs(ctx context.Context, q db.SongsQuery) (result []globalStructs.Song, total int, err error) {
+	ctx, span := d.start(ctx, "GetSongs", d.c.Songs, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetSongs(ctx, q)
+}
+
+func (d *DB) SearchSongs(ctx context.Context, q db.SearchQuery) (result []globalStructs.Song, total int, err error) {
+	ctx, span := d.start(ctx, "SearchSongs", d.c.Songs, opFind)
+	defer func() { end(span, err) }()
+	return d.d.SearchSongs(ctx, q)
+}
+
+func (d *DB) GetSegment(ctx context.Context, id string) (result globalStructs.SongData, err error) {
+	ctx, span := d.start(ctx, "GetSegment", d.c.Segments, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetSegment(ctx, id)
+}
+
+func (d *DB) GetSegmentSongID(ctx context.Context, id string) (songID string, err error) {
+	ctx, span := d.start(ctx, "GetSegmentSongID", d.c.Segments, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetSegmentSongID(ctx, id)
+}
+
+func (d *DB) GetSegmentInfo(ctx context.Context, id string) (result globalStructs.SongData, created time.Time, err error) {
+	ctx, span := d.start(ctx, "GetSegmentInfo", d.c.Segments, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetSegmentInfo(ctx, id)
+}
+
+// InsertSegment - span also has number and total size of inserted segments
+func (d *DB) InsertSegment(ctx context.Context, songID string, ts ...globalStructs.SongData) (err error) {
+	var size int
+	for _, v := range ts {
+		size += len(v.Data)
+	}
+	ctx, span := d.start(ctx, "InsertSegment", d.c.Segments, opInsert,
+		attribute.Int("segments.count", len(ts)), attribute.Int("segments.bytes", size))
+	defer func() { end(span, err) }()
+	return d.d.InsertSegment(ctx, songID, ts...)
+}
+
+func (d *DB) FindSegmentIDs(ctx context.Context, ids ...string) (found []string, err error) {
+	ctx, span := d.start(ctx, "FindSegmentIDs", d.c.Segments, opFind)
+	defer func() { end(span, err) }()
+	return d.d.FindSegmentIDs(ctx, ids...)
+}
+
+func (d *DB) DeleteSegments(ctx context.Context, songID string, ids ...string) (err error) {
+	ctx, span := d.start(ctx, "DeleteSegments", d.c.Segments, opDelete)
+	defer func() { end(span, err) }()
+	return d.d.DeleteSegments(ctx, songID, ids...)
+}
+
+func (d *DB) InsertSong(ctx context.Context, s globalStructs.Song) (err error) {
+	ctx, span := d.start(ctx, "InsertSong", d.c.Songs, opInsert)
+	defer func() { end(span, err) }()
+	return d.d.InsertSong(ctx, s)
+}
+
+func (d *DB) GetSongByID(ctx context.Context, id string) (s globalStructs.Song, err error) {
+	ctx, span := d.start(ctx, "GetSongByID", d.c.Songs, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetSongByID(ctx, id)
+}
+
+func (d *DB) DeleteSong(ctx context.Context, id string, legacy []string, dryRun bool) (result db.DeleteSongResult, err error) {
+	ctx, span := d.start(ctx, "DeleteSong", d.c.Songs, opDelete, attribute.Bool("dry_run", dryRun))
+	defer func() { end(span, err) }()
+	return d.d.DeleteSong(ctx, id, legacy, dryRun)
+}
+
+func (d *DB) UpdateSong(ctx context.Context, u db.SongUpdate) (playlists int, err error) {
+	ctx, span := d.start(ctx, "UpdateSong", d.c.Songs, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.UpdateSong(ctx, u)
+}
+
+func (d *DB) GetUserByID(ctx context.Context, id string) (resp globalStructs.User, err error) {
+	ctx, span := d.start(ctx, "GetUserByID", d.c.Users, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetUserByID(ctx, id)
+}
+
+func (d *DB) GetUserRoles(ctx context.Context, id string) (roles []string, err error) {
+	ctx, span := d.start(ctx, "GetUserRoles", d.c.Users, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetUserRoles(ctx, id)
+}
+
+func (d *DB) SetUserRoles(ctx context.Context, id string, roles []string) (err error) {
+	ctx, span := d.start(ctx, "SetUserRoles", d.c.Users, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.SetUserRoles(ctx, id, roles)
+}
+
+func (d *DB) NewUser(ctx context.Context, u globalStructs.User) (err error) {
+	ctx, span := d.start(ctx, "NewUser", d.c.Users, opInsert)
+	defer func() { end(span, err) }()
+	return d.d.NewUser(ctx, u)
+}
+
+func (d *DB) NewPlaylist(ctx context.Context, p globalStructs.Playlist) (err error) {
+	ctx, span := d.start(ctx, "NewPlaylist", d.c.Playlists, opInsert)
+	defer func() { end(span, err) }()
+	return d.d.NewPlaylist(ctx, p)
+}
+
+func (d *DB) DeleteUserPlaylist(ctx context.Context, id, owner string) (err error) {
+	ctx, span := d.start(ctx, "DeleteUserPlaylist", d.c.Playlists, opDelete)
+	defer func() { end(span, err) }()
+	return d.d.DeleteUserPlaylist(ctx, id, owner)
+}
+
+func (d *DB) DeletePlaylistByID(ctx context.Context, id string) (err error) {
+	ctx, span := d.start(ctx, "DeletePlaylistByID", d.c.Playlists, opDelete)
+	defer func() { end(span, err) }()
+	return d.d.DeletePlaylistByID(ctx, id)
+}
+
+func (d *DB) GetPlaylistByID(ctx context.Context, id string) (p globalStructs.Playlist, err error) {
+	ctx, span := d.start(ctx, "GetPlaylistByID", d.c.Playlists, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetPlaylistByID(ctx, id)
+}
+
+func (d *DB) AddSongsToUserPlaylist(ctx context.Context, id, owner string, song globalStructs.Song) (err error) {
+	ctx, span := d.start(ctx, "AddSongsToUserPlaylist", d.c.Playlists, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.AddSongsToUserPlaylist(ctx, id, owner, song)
+}
+
+func (d *DB) AddSongsToPlaylist(ctx context.Context, id string, song globalStructs.Song) (err error) {
+	ctx, span := d.start(ctx, "AddSongsToPlaylist", d.c.Playlists, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.AddSongsToPlaylist(ctx, id, song)
+}
+
+func (d *DB) RemoveSongFromUserPlaylist(ctx context.Context, id, owner, songID string) (err error) {
+	ctx, span := d.start(ctx, "RemoveSongFromUserPlaylist", d.c.Playlists, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.RemoveSongFromUserPlaylist(ctx, id, owner, songID)
+}
+
+func (d *DB) RemoveSongFromPlaylist(ctx context.Context, id, songID string) (err error) {
+	ctx, span := d.start(ctx, "RemoveSongFromPlaylist", d.c.Playlists, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.RemoveSongFromPlaylist(ctx, id, songID)
+}
+
+func (d *DB) GetAllUserPlaylists(ctx context.Context, owner string) (p []globalStructs.ShortPlaylist, err error) {
+	ctx, span := d.start(ctx, "GetAllUserPlaylists", d.c.Playlists, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetAllUserPlaylists(ctx, owner)
+}
+
+func (d *DB) NewUploadSession(ctx context.Context, s db.UploadSession) (id string, err error) {
+	ctx, span := d.start(ctx, "NewUploadSession", d.c.UploadSessions, opInsert)
+	defer func() { end(span, err) }()
+	return d.d.NewUploadSession(ctx, s)
+}
+
+func (d *DB) GetUploadSession(ctx context.Context, id string) (s db.UploadSession, err error) {
+	ctx, span := d.start(ctx, "GetUploadSession", d.c.UploadSessions, opFind)
+	defer func() { end(span, err) }()
+	return d.d.GetUploadSession(ctx, id)
+}
+
+func (d *DB) SetUploadSegment(ctx context.Context, id, key, segmentID string, expires time.Time) (err error) {
+	ctx, span := d.start(ctx, "SetUploadSegment", d.c.UploadSessions, opUpdate)
+	defer func() { end(span, err) }()
+	return d.d.SetUploadSegment(ctx, id, key, segmentID, expires)
+}
+
+func (d *DB) DeleteUploadSession(ctx context.Context, id string) (err error) {
+	ctx, span := d.start(ctx, "DeleteUploadSession", d.c.UploadSessions, opDelete)
+	defer func() { end(span, err) }()
+	return d.d.DeleteUploadSession(ctx, id)
+}
+
+func (d *DB) ExpiredUploadSessions(ctx context.Context, now time.Time, limit int) (result []db.UploadSession, err error) {
+	ctx, span := d.start(ctx, "ExpiredUploadSessions", d.c.UploadSessions, opFind)
+	defer func() { end(span, err) }()
+	return d.d.ExpiredUploadSessions(ctx, now, limit)
+}
+
+func (d *DB) Ping(ctx context.Context) (err error) {
+	ctx, span := d.start(ctx, "Ping", "", opPing)
+	defer func() { end(span, err) }()
+	return d.d.Ping(ctx)
+}
+
+// Close - not traced, it runs after the last request
+func (d *DB) Close(ctx context.Context) error {
+	return d.d.Close(ctx)
+}
@@ -0,0 +1,191 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/service"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Service - IService decorator which starts a span for every method, failed spans get
+// error code of service error
+type Service struct {
+	s service.IService
+	t trace.Tracer
+}
+
+// NewService - wraps s
+func NewService(s service.IService, t trace.Tracer) *Service {
+	return &Service{s: s, t: t}
+}
+
+func (s *Service) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return s.t.Start(ctx, "Service."+method)
+}
+
+// endService - ends span of service method with error code of err
+func endService(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("error.code", service.Code(err)))
+	}
+	end(span, err)
+}
+
+func (s *Service) NewSegments(ctx context.Context, req structs.AddSegmentsReq) (resp structs.AddSegmentsResp, err error) {
+	ctx, span := s.start(ctx, "NewSegments")
+	defer func() { endService(span, err) }()
+	return s.s.NewSegments(ctx, req)
+}
+
+func (s *Service) UploadSegments(ctx context.Context, song globalStructs.Song, next service.SegmentSource) (resp structs.AddSegmentsResp, err error) {
+	ctx, span := s.start(ctx, "UploadSegments")
+	defer func() { endService(span, err) }()
+	return s.s.UploadSegments(ctx, song, next)
+}
+
+func (s *Service) GetAllSongs(ctx context.Context) (resp structs.GetAllSongsResp, err error) {
+	ctx, span := s.start(ctx, "GetAllSongs")
+	defer func() { endService(span, err) }()
+	return s.s.GetAllSongs(ctx)
+}
+
+func (s *Service) GetSongs(ctx context.Context, req structs.GetSongsReq) (resp structs.GetSongsResp, err error) {
+	ctx, span := s.start(ctx, "GetSongs")
+	defer func() { endService(span, err) }()
+	return s.s.GetSongs(ctx, req)
+}
+
+func (s *Service) Search(ctx context.Context, req structs.SearchReq) (resp structs.SearchResp, err error) {
+	ctx, span := s.start(ctx, "Search")
+	defer func() { endService(span, err) }()
+	return s.s.Search(ctx, req)
+}
+
+func (s *Service) GetSegment(ctx context.Context, req structs.GetSegmentReq) (resp structs.GetSegmentResp, err error) {
+	ctx, span := s.start(ctx, "GetSegment")
+	defer func() { endService(span, err) }()
+	return s.s.GetSegment(ctx, req)
+}
+
+func (s *Service) DeleteSong(ctx context.Context, req structs.DeleteSongReq) (resp structs.DeleteSongResp, err error) {
+	ctx, span := s.start(ctx, "DeleteSong")
+	defer func() { endService(span, err) }()
+	return s.s.DeleteSong(ctx, req)
+}
+
+func (s *Service) UpdateSong(ctx context.Context, req structs.UpdateSongReq) (resp structs.UpdateSongResp, err error) {
+	ctx, span := s.start(ctx, "UpdateSong")
+	defer func() { endService(span, err) }()
+	return s.s.UpdateSong(ctx, req)
+}
+
+func (s *Service) GetUser(ctx context.Context, req structs.GetUserReq) (resp structs.GetUserResp, err error) {
+	ctx, span := s.start(ctx, "GetUser")
+	defer func() { endService(span, err) }()
+	return s.s.GetUser(ctx, req)
+}
+
+func (s *Service) NewUser(ctx context.Context, req globalStructs.User) (resp structs.NewUserResp, err error) {
+	ctx, span := s.start(ctx, "NewUser")
+	defer func() { endService(span, err) }()
+	return s.s.NewUser(ctx, req)
+}
+
+func (s *Service) NewPlaylist(ctx context.Context, req structs.NewPlaylistReq) (resp structs.NewPlaylistResp, err error) {
+	ctx, span := s.start(ctx, "NewPlaylist")
+	defer func() { endService(span, err) }()
+	return s.s.NewPlaylist(ctx, req)
+}
+
+func (s *Service) DeletePlaylist(ctx context.Context, req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error) {
+	ctx, span := s.start(ctx, "DeletePlaylist")
+	defer func() { endService(span, err) }()
+	return s.s.DeletePlaylist(ctx, req)
+}
+
+func (s *Service) DeleteUserPlaylist(ctx context.Context, req structs.DeleteUserPlaylistReq) (resp structs.DeleteUserPlaylistResp, err error) {
+	ctx, span := s.start(ctx, "DeleteUserPlaylist")
+	defer func() { endService(span, err) }()
+	return s.s.DeleteUserPlaylist(ctx, req)
+}
+
+func (s *Service) AddSongToUserPlaylist(ctx context.Context, req structs.AddSongToUserPlaylistReq) (resp structs.AddSongToUserPlaylistResp, err error) {
+	ctx, span := s.start(ctx, "AddSongToUserPlaylist")
+	defer func() { endService(span, err) }()
+	return s.s.AddSongToUserPlaylist(ctx, req)
+}
+
+func (s *Service) RemoveSongFromUserPlaylist(ctx context.Context, req structs.RemoveSongFromUserPlaylistReq) (resp structs.RemoveSongFromUserPlaylistResp, err error) {
+	ctx, span := s.start(ctx, "RemoveSongFromUserPlaylist")
+	defer func() { endService(span, err) }()
+	return s.s.RemoveSongFromUserPlaylist(ctx, req)
+}
+
+func (s *Service) GetUserPlaylists(ctx context.Context, req structs.GetUserAllPlaylistsReq) (resp structs.GetUserAllPlaylistsResp, err error) {
+	ctx, span := s.start(ctx, "GetUserPlaylists")
+	defer func() { endService(span, err) }()
+	return s.s.GetUserPlaylists(ctx, req)
+}
+
+func (s *Service) GetUserPlaylist(ctx context.Context, req structs.GetPlaylistReq) (resp structs.GetPlaylistResp, err error) {
+	ctx, span := s.start(ctx, "GetUserPlaylist")
+	defer func() { endService(span, err) }()
+	return s.s.GetUserPlaylist(ctx, req)
+}
+
+func (s *Service) SetUserRoles(ctx context.Context, req structs.SetUserRolesReq) (resp structs.SetUserRolesResp, err error) {
+	ctx, span := s.start(ctx, "SetUserRoles")
+	defer func() { endService(span, err) }()
+	return s.s.SetUserRoles(ctx, req)
+}
+
+func (s *Service) CreateUpload(ctx context.Context, req structs.CreateUploadReq) (resp structs.UploadSessionResp, err error) {
+	ctx, span := s.start(ctx, "CreateUpload")
+	defer func() { endService(span, err) }()
+	return s.s.CreateUpload(ctx, req)
+}
+
+func (s *Service) GetUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.UploadSessionResp, err error) {
+	ctx, span := s.start(ctx, "GetUpload")
+	defer func() { endService(span, err) }()
+	return s.s.GetUpload(ctx, req)
+}
+
+// UploadSegment - span also has part and size of the segment
+func (s *Service) UploadSegment(ctx context.Context, req structs.UploadSegmentReq, part string, segment globalStructs.SongData) (resp structs.UploadSessionResp, err error) {
+	ctx, span := s.start(ctx, "UploadSegment")
+	span.SetAttributes(attribute.String("upload.part", part), attribute.Int("segments.bytes", len(segment.Data)))
+	defer func() { endService(span, err) }()
+	return s.s.UploadSegment(ctx, req, part, segment)
+}
+
+func (s *Service) CommitUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.CommitUploadResp, err error) {
+	ctx, span := s.start(ctx, "CommitUpload")
+	defer func() { endService(span, err) }()
+	return s.s.CommitUpload(ctx, req)
+}
+
+func (s *Service) AbortUpload(ctx context.Context, req structs.UploadSessionReq) (resp structs.AbortUploadResp, err error) {
+	ctx, span := s.start(ctx, "AbortUpload")
+	defer func() { endService(span, err) }()
+	return s.s.AbortUpload(ctx, req)
+}
+
+// CleanupUploads - span also has number of removed sessions
+func (s *Service) CleanupUploads(ctx context.Context) (removed int, err error) {
+	ctx, span := s.start(ctx, "CleanupUploads")
+	defer func() {
+		span.SetAttributes(attribute.Int("upload.sessions_removed", removed))
+		endService(span, err)
+	}()
+	return s.s.CleanupUploads(ctx)
+}
+
+func (s *Service) Ready(ctx context.Context) (err error) {
+	ctx, span := s.start(ctx, "Ready")
+	defer func() { endService(span, err) }()
+	return s.s.Ready(ctx)
+}
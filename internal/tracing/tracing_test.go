@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/policy"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/service"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// recorded - tracer whose ended spans are kept by recorder
+func recorded(t *testing.T) (trace.Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	return tp.Tracer(Name), recorder
+}
+
+// traced - service and memory db decorated the way main does it
+func traced(t *testing.T) (*Service, *tracetest.SpanRecorder) {
+	tracer, recorder := recorded(t)
+	d := NewDB(db.NewMemoryDB(zap.NewNop()), tracer, "memory", config.Default().Mongo.Collections)
+	s := NewService(service.NewService(d, policy.New(nil), config.Upload{}, zap.NewNop()), tracer)
+	return s, recorder
+}
+
+func addSegmentsReq(songID string) structs.AddSegmentsReq {
+	return structs.AddSegmentsReq{
+		M3H8:     globalStructs.SongData{ID: songID + "-m3h8", Data: []byte("#EXTM3U")},
+		Ts:       []globalStructs.SongData{{ID: songID + "-ts0", Data: []byte("ts")}},
+		SongData: globalStructs.Song{ID: songID, Name: "name"},
+	}
+}
+
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestServiceSpans(t *testing.T) {
+	s, recorder := traced(t)
+
+	if _, err := s.NewSegments(context.Background(), addSegmentsReq("song")); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	root := spans[len(spans)-1]
+	if root.Name() != "Service.NewSegments" {
+		t.Fatalf("last ended span is %s, want Service.NewSegments", root.Name())
+	}
+	if root.Parent().IsValid() {
+		t.Errorf("service span has parent %s", root.Parent().SpanID())
+	}
+	if root.Status().Code != codes.Unset {
+		t.Errorf("service span status = %v", root.Status())
+	}
+
+	children := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans[:len(spans)-1] {
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of service span", span.Name())
+		}
+		children[span.Name()] = span
+	}
+	for _, name := range []string{"IDB.FindSegmentIDs", "IDB.GetSongByID", "IDB.InsertSegment", "IDB.InsertSong"} {
+		if _, ok := children[name]; !ok {
+			t.Errorf("no %s span, got %v", name, children)
+		}
+	}
+
+	insert := children["IDB.InsertSegment"]
+	if v, _ := attr(insert, semconv.DBSystemKey); v.AsString() != "memory" {
+		t.Errorf("db.system = %q", v.AsString())
+	}
+	if v, _ := attr(insert, semconv.DBMongoDBCollectionKey); v.AsString() != config.Default().Mongo.Collections.Segments {
+		t.Errorf("collection = %q", v.AsString())
+	}
+}
+
+func TestServiceSpanErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(s *Service) error
+		span string
+		code string
+	}{
+		{
+			name: "conflict",
+			call: func(s *Service) error {
+				if _, err := s.NewSegments(context.Background(), addSegmentsReq("song")); err != nil {
+					return err
+				}
+				_, err := s.NewSegments(context.Background(), addSegmentsReq("song"))
+				return err
+			},
+			span: "Service.NewSegments",
+			code: structs.ErrCodeConflict,
+		},
+		{
+			name: "not found",
+			call: func(s *Service) error {
+				_, err := s.GetSegment(context.Background(), structs.GetSegmentReq{ID: "missing"})
+				return err
+			},
+			span: "Service.GetSegment",
+			code: structs.ErrCodeNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, recorder := traced(t)
+			if err := tt.call(s); err == nil {
+				t.Fatal("call did not fail")
+			}
+
+			spans := recorder.Ended()
+			root := spans[len(spans)-1]
+			if root.Name() != tt.span {
+				t.Fatalf("last ended span is %s, want %s", root.Name(), tt.span)
+			}
+			if root.Status().Code != codes.Error {
+				t.Errorf("status = %v, want error", root.Status())
+			}
+			if v, ok := attr(root, "error.code"); !ok || v.AsString() != tt.code {
+				t.Errorf("error.code = %q, want %q", v.AsString(), tt.code)
+			}
+			if len(root.Events()) == 0 || root.Events()[0].Name != "exception" {
+				t.Errorf("error is not recorded, events %v", root.Events())
+			}
+		})
+	}
+}
+
+func TestDBSpanError(t *testing.T) {
+	tracer, recorder := recorded(t)
+	d := NewDB(db.NewMemoryDB(zap.NewNop()), tracer, "memory", config.Default().Mongo.Collections)
+
+	if _, err := d.GetSegment(context.Background(), "missing"); err != db.ErrNotFound {
+		t.Fatalf("err = %v, want not found", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "IDB.GetSegment" {
+		t.Fatalf("spans = %v", spans)
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("status = %v, want error", spans[0].Status())
+	}
+}
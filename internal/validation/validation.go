@@ -0,0 +1,159 @@
+// Package validation adds request rules to gin binding validator and converts its errors
+// to field level errors of responses
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/shared/structs"
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+)
+
+const (
+	// MaxIDLength - max length of song, segment, user and playlist ids
+	MaxIDLength = 128
+	// MaxSegmentSize - max size of m3h8 or ts segment data in bytes
+	MaxSegmentSize = 16 << 20
+	// MaxNameLength - max length of song name, artist and album
+	MaxNameLength = 256
+)
+
+// IDPattern - characters allowed in ids
+const IDPattern = `^[A-Za-z0-9._-]+$`
+
+var idRegexp = regexp.MustCompile(IDPattern)
+
+// Register - adds id rule and struct validations of globalStructs types to gin validator,
+// must be called before requests are served
+func Register() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("gin validator is not go-playground validator")
+	}
+
+	v.RegisterTagNameFunc(fieldName)
+	if err := v.RegisterValidation("id", func(fl validator.FieldLevel) bool {
+		return IsID(fl.Field().String())
+	}); err != nil {
+		return err
+	}
+	v.RegisterStructValidation(songData, globalStructs.SongData{})
+	v.RegisterStructValidation(song, globalStructs.Song{})
+	v.RegisterStructValidation(user, globalStructs.User{})
+	return nil
+}
+
+// Struct - validates struct which was not bound by gin, e.g. built from path params
+func Struct(obj interface{}) error {
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// IsID - checks that id consists of letters, digits, '.', '_' or '-' and is not longer than MaxIDLength
+func IsID(id string) bool {
+	return len(id) <= MaxIDLength && idRegexp.MatchString(id)
+}
+
+// Fields - field errors of validation error, nil if err is not a validation error
+func Fields(err error) []structs.FieldError {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return nil
+	}
+
+	result := make([]structs.FieldError, 0, len(verrs))
+	for _, v := range verrs {
+		result = append(result, structs.FieldError{
+			Field:   path(v.Namespace()),
+			Rule:    v.Tag(),
+			Param:   v.Param(),
+			Message: message(v.Tag(), v.Param()),
+		})
+	}
+	return result
+}
+
+// fieldName - json name of field so that errors point to request keys, form name for query only fields
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name := strings.Split(f.Tag.Get(tag), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// path - namespace without the name of validated struct, e.g. AddSegmentsReq.ts[2].id is ts[2].id
+func path(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func message(rule, param string) string {
+	switch rule {
+	case "required":
+		return "is required"
+	case "id":
+		return fmt.Sprintf("must consist of letters, digits, '.', '_' or '-' and be at most %d characters", MaxIDLength)
+	case "min":
+		return "must be at least " + param
+	case "max":
+		return "must be at most " + param
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(param, " ", ", ")
+	}
+	return "failed " + rule + " rule"
+}
+
+func songData(sl validator.StructLevel) {
+	s := sl.Current().Interface().(globalStructs.SongData)
+	reportID(sl, s.ID, "id", "ID")
+	switch {
+	case len(s.Data) == 0:
+		sl.ReportError(s.Data, "data", "Data", "required", "")
+	case len(s.Data) > MaxSegmentSize:
+		sl.ReportError(s.Data, "data", "Data", "max", strconv.Itoa(MaxSegmentSize))
+	}
+}
+
+func song(sl validator.StructLevel) {
+	s := sl.Current().Interface().(globalStructs.Song)
+	reportID(sl, s.ID, "id", "ID")
+	if s.Name == "" {
+		sl.ReportError(s.Name, "name", "Name", "required", "")
+	}
+	for _, f := range []struct{ value, name, field string }{
+		{s.Name, "name", "Name"},
+		{s.Artist, "artist", "Artist"},
+		{s.Album, "album", "Album"},
+	} {
+		if len(f.value) > MaxNameLength {
+			sl.ReportError(f.value, f.name, f.field, "max", strconv.Itoa(MaxNameLength))
+		}
+	}
+}
+
+func user(sl validator.StructLevel) {
+	u := sl.Current().Interface().(globalStructs.User)
+	reportID(sl, u.ID, "id", "ID")
+}
+
+func reportID(sl validator.StructLevel, id, name, field string) {
+	if id == "" {
+		sl.ReportError(id, name, field, "required", "")
+	} else if !IsID(id) {
+		sl.ReportError(id, name, field, "id", "")
+	}
+}
@@ -1,41 +1,159 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/auth"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/config"
 	db2 "github.com/supperdoggy/spotify-web-project/spotify-db/internal/db"
 	handlers2 "github.com/supperdoggy/spotify-web-project/spotify-db/internal/handlers"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/logging"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/metrics"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/openapi"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/policy"
 	service2 "github.com/supperdoggy/spotify-web-project/spotify-db/internal/service"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/tracing"
+	"github.com/supperdoggy/spotify-web-project/spotify-db/internal/validation"
 	"go.uber.org/zap"
 )
 
 func main() {
-	r := gin.Default()
-	logger, _ := zap.NewDevelopment()
-	db, err := db2.NewDB("spotify", logger)
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		// logger settings are part of the config, so its errors are logged with the defaults
+		logger, _ := logging.New(config.Default().Log)
+		logger.Fatal("error loading config", zap.Error(err))
+	}
+	logger, err := logging.New(cfg.Log)
+	if err != nil {
+		log.Fatalf("error creating logger: %s", err)
+	}
+	defer logger.Sync()
+
+	if !cfg.Log.Development {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	if err := validation.Register(); err != nil {
+		logger.Fatal("error registering request validation", zap.Error(err))
+	}
+
+	registry := prometheus.NewRegistry()
+	m := metrics.New(registry)
+
+	var db db2.IDB
+	if cfg.InMemory {
+		logger.Info("using in-memory db")
+		db = db2.NewMemoryDB(logger)
+	} else {
+		db, err = db2.NewDB(cfg.Mongo, logger)
+		if err != nil {
+			logger.Fatal("error connecting to db", zap.Error(err))
+		}
+	}
+	tp, shutdownTracing, err := tracing.New(context.Background(), cfg.Tracing)
 	if err != nil {
-		logger.Fatal("error connecting to db", zap.Error(err))
+		logger.Fatal("error setting up tracing", zap.Error(err))
+	}
+	tracer := tp.Tracer(tracing.Name)
+
+	system := "mongodb"
+	if cfg.InMemory {
+		system = "memory"
 	}
-	service := service2.NewService(db, logger)
-	handlers := handlers2.NewHandlers(service, logger)
+	db = tracing.NewDB(metrics.NewDB(db, m), tracer, system, cfg.Mongo.Collections)
+	service := tracing.NewService(service2.NewService(db, policy.New(cfg.Auth.Admins), cfg.Upload, logger), tracer)
+	handlers := handlers2.NewHandlers(service, cfg.Upload, logger)
 
-	apiv1 := r.Group("/api/v1")
-	{
-		apiv1.POST("/addSegment", handlers.AddSegments)
-		apiv1.GET("/allsongs", handlers.GetAllSongs)
-		apiv1.POST("/getsegment", handlers.GetSegment)
-		apiv1.POST("/new_user", handlers.NewUser)
-		apiv1.POST("/get_user", handlers.GetUser)
+	r.Use(
+		handlers2.Metrics(m),
+		handlers2.Tracing(tracer),
+		handlers2.RequestID(logger),
+		handlers2.AccessLog(logger),
+		handlers2.Timeout(cfg.Timeouts.For),
+	)
 
-		// playlists
-		apiv1.POST("/new_playlist", handlers.NewPlaylist)
-		apiv1.POST("/delete_playlist", handlers.DeletePlaylist)
-		apiv1.POST("/user_playlists", handlers.GetUserPlaylists)
-		apiv1.POST("/get_playlist", handlers.GetUserPlaylist)
-		apiv1.POST("/add_song_playlist", handlers.AddSongToUserPlaylist)
-		apiv1.POST("/remove_song_playlist", handlers.RemoveSongFromUserPlaylist)
+	var api []gin.HandlerFunc
+	if cfg.Auth.Disabled {
+		logger.Warn("auth is disabled, user ids from requests are trusted")
+	} else {
+		authenticator, err := auth.New(cfg.Auth)
+		if err != nil {
+			logger.Fatal("error creating authenticator", zap.Error(err))
+		}
+		api = append(api, handlers2.Auth(authenticator, logger))
 	}
 
-	if err := r.Run(":8082"); err != nil {
-		logger.Fatal("error running db service")
+	doc := openapi.New(openapi.Info{Title: "spotify-db", Version: "2"}, handlers2.Operations())
+	handlers2.Register(r, &handlers, doc, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), api...)
+
+	// expired upload sessions are removed in the background until shutdown
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	cleanupDone := make(chan struct{})
+	go func() {
+		defer close(cleanupDone)
+		ticker := time.NewTicker(cfg.Upload.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cleanupCtx.Done():
+				return
+			case <-ticker.C:
+				removed, err := service.CleanupUploads(cleanupCtx)
+				if err != nil {
+					logger.Error("error removing expired upload sessions", zap.Error(err))
+				}
+				if removed != 0 {
+					logger.Info("removed expired upload sessions", zap.Int("count", removed))
+				}
+			}
+		}
+	}()
+
+	srv := &http.Server{Addr: cfg.Listen, Handler: r}
+	errs := make(chan error, 1)
+	go func() {
+		logger.Info("listening", zap.String("addr", cfg.Listen))
+		errs <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		logger.Fatal("error running db service", zap.Error(err))
+	case sig := <-stop:
+		logger.Info("shutting down", zap.String("signal", sig.String()))
+	}
+
+	// in-flight requests are drained first so uploads are not cut mid-write, db is closed after them
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("error draining requests", zap.Error(err))
+	}
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("error running db service", zap.Error(err))
+	}
+	stopCleanup()
+	<-cleanupDone
+	if err := db.Close(ctx); err != nil {
+		logger.Error("error closing db", zap.Error(err))
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("error flushing spans", zap.Error(err))
 	}
+	logger.Info("stopped")
 }
@@ -1,105 +1,313 @@
 package structs
 
-import globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+import (
+	globalStructs "github.com/supperdoggy/spotify-web-project/spotify-globalStructs"
+	"time"
+)
 
+// error codes of ErrorResp, each one is answered with its own http status
+const (
+	// ErrCodeBadRequest - request body or query could not be parsed
+	ErrCodeBadRequest = "bad_request"
+	ErrCodeValidation = "validation_failed"
+	// ErrCodeUnauthorized - request has no valid bearer token
+	ErrCodeUnauthorized = "unauthorized"
+	ErrCodeNotFound     = "not_found"
+	ErrCodeForbidden    = "forbidden"
+	ErrCodeConflict     = "conflict"
+	ErrCodeTimeout      = "timeout"
+	ErrCodeInternal     = "internal"
+	// ErrCodeTooLarge - upload is larger than the service accepts
+	ErrCodeTooLarge = "too_large"
+)
+
+// ErrorResp - error envelope embedded in every response, Error is human readable message and
+// Code is one of ErrCode* values. Both are empty when request succeeded
+type ErrorResp struct {
+	Error  string       `json:"error"`
+	Code   string       `json:"code,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError - failed validation rule of a single request field, Field is json path of the field,
+// e.g. ts[2].id, Param is parameter of the rule such as max length
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// AddSegmentsReq - song is limited to 2000 ts segments, segments and song data are checked by
+// struct validations registered in internal/validation. Songs have no owner, upload is authorized
+// by roles of the caller, so user_id sent by older clients is ignored
 type AddSegmentsReq struct {
-	UserID   string                   `json:"user_id"`
-	Ts       []globalStructs.SongData `json:"ts"`
+	Ts       []globalStructs.SongData `json:"ts" binding:"required,min=1,max=2000,dive"`
 	M3H8     globalStructs.SongData   `json:"m3h8"`
 	SongData globalStructs.Song       `json:"song_data"`
 }
 
+// AddSegmentsResp - FailedPart is set when ingestion fails and tells which part of the request
+// caused it, one of IngestPart* values. FailedSegments holds ids of segments which were rejected
 type AddSegmentsResp struct {
-	OK    bool   `json:"ok"`
-	Error string `json:"error"`
+	ErrorResp
+	OK             bool     `json:"ok"`
+	FailedPart     string   `json:"failed_part"`
+	FailedSegments []string `json:"failed_segments"`
 }
 
+const (
+	IngestPartValidation = "validation"
+	IngestPartSongData   = "song_data"
+	IngestPartM3H8       = "m3h8"
+	IngestPartTs         = "ts"
+)
+
 type GetAllSongsResp struct {
-	Error string               `json:"error"`
+	ErrorResp
 	Songs []globalStructs.Song `json:"songs"`
 }
 
+// GetSongsReq - sort_by is one of name, artist or uploaded (default), songs with equal sort field
+// are ordered by id. Name, artist and album filter songs by case insensitive substring
+type GetSongsReq struct {
+	Offset int    `json:"offset" form:"offset" binding:"min=0"`
+	Limit  int    `json:"limit" form:"limit" binding:"min=0,max=1000"`
+	SortBy string `json:"sort_by" form:"sort_by" binding:"omitempty,oneof=uploaded name artist"`
+	Desc   bool   `json:"desc" form:"desc"`
+	Name   string `json:"name" form:"name" binding:"max=256"`
+	Artist string `json:"artist" form:"artist" binding:"max=256"`
+	Album  string `json:"album" form:"album" binding:"max=256"`
+}
+
+type GetSongsResp struct {
+	ErrorResp
+	Songs  []globalStructs.Song `json:"songs"`
+	Total  int                  `json:"total"`
+	Offset int                  `json:"offset"`
+	Limit  int                  `json:"limit"`
+}
+
+// SearchReq - q is matched against song name, artist and album, last word may be incomplete
+type SearchReq struct {
+	Query  string `json:"q" form:"q" binding:"required,max=256"`
+	Offset int    `json:"offset" form:"offset" binding:"min=0"`
+	Limit  int    `json:"limit" form:"limit" binding:"min=0,max=1000"`
+}
+
+// SearchResp - Total counts every matching song, but at most 1000 newest matches are ranked
+// (db.SearchCandidatesLimit), so when Total is larger pages past them are empty
+type SearchResp struct {
+	ErrorResp
+	Songs  []globalStructs.Song `json:"songs"`
+	Total  int                  `json:"total"`
+	Offset int                  `json:"offset"`
+	Limit  int                  `json:"limit"`
+}
+
 type GetSegmentReq struct {
-	ID string `json:"id"`
+	ID string `json:"id" binding:"required,id"`
 }
 
+// GetSegmentResp - Modified is the time segment was inserted, zero if unknown
 type GetSegmentResp struct {
-	Segment globalStructs.SongData `json:"segment"`
-	Error   string                 `json:"error"`
+	ErrorResp
+	Segment  globalStructs.SongData `json:"segment"`
+	Modified time.Time              `json:"modified"`
+}
+
+// UpdateSongReq - changes fields of song which are sent, fields which are not sent are kept,
+// copies of the song in playlists are changed as well
+type UpdateSongReq struct {
+	Song SongUpdate `json:"song"`
+}
+
+// SongUpdate - id of song to change and its new fields, at least one field must be sent
+type SongUpdate struct {
+	ID     string  `json:"id" binding:"required,id"`
+	Name   *string `json:"name" binding:"omitnil,min=1,max=256"`
+	Artist *string `json:"artist" binding:"omitnil,max=256"`
+	Album  *string `json:"album" binding:"omitnil,max=256"`
+}
+
+type UpdateSongResp struct {
+	ErrorResp
+	OK        bool `json:"ok"`
+	Playlists int  `json:"playlists"`
+}
+
+// DeleteSongReq - with dry_run nothing is removed, response only lists what would be.
+// Segments of songs uploaded before segments were linked to their song are found only
+// through the song m3h8, pass its id in m3h8 to remove them too
+type DeleteSongReq struct {
+	SongID string `json:"song_id" binding:"required,id"`
+	M3H8   string `json:"m3h8" binding:"omitempty,id"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// DeleteSongResp - partial is set when delete failed after song was removed from some playlists
+// or some segments were removed, song itself is kept so the same request can be retried
+type DeleteSongResp struct {
+	ErrorResp
+	OK        bool     `json:"ok"`
+	DryRun    bool     `json:"dry_run"`
+	Partial   bool     `json:"partial"`
+	Segments  []string `json:"segments"`
+	Playlists []string `json:"playlists"`
 }
 
 type NewUserResp struct {
-	OK    bool   `json:"ok"`
-	Error string `json:"error"`
+	ErrorResp
+	OK bool `json:"ok"`
 }
 
+// GetUserReq - authenticated users may get only themselves, admins may get anyone
 type GetUserReq struct {
-	ID string `json:"id"`
+	ID string `json:"id" uri:"id" binding:"required,id"`
 }
 
 type GetUserResp struct {
-	User  globalStructs.User `json:"user"`
-	Error string             `json:"error"`
+	ErrorResp
+	User globalStructs.User `json:"user"`
 }
 
+// NewPlaylistReq - authenticated users may leave user_id of playlist requests empty, when
+// it is set it must be their own id or the request is forbidden. Services acting without
+// X-User-ID header and callers when auth is disabled must set it
 type NewPlaylistReq struct {
-	UserID       string `json:"user_id"`
-	PlaylistName string `json:"playlist_name"`
-	Description  string `json:"description"`
+	UserID       string `json:"user_id" binding:"omitempty,id"`
+	PlaylistName string `json:"playlist_name" binding:"required,max=128"`
+	Description  string `json:"description" binding:"max=1024"`
 	Shared       bool   `json:"shared"`
 }
 
 type NewPlaylistResp struct {
-	Error string `json:"error"`
-	OK    bool   `json:"ok"`
+	ErrorResp
+	OK bool `json:"ok"`
 }
 
 type DeleteUserPlaylistReq struct {
-	UserID     string `json:"user_id"`
-	PlaylistID string `json:"playlist_id"`
+	UserID     string `json:"user_id" form:"user_id" binding:"omitempty,id"`
+	PlaylistID string `json:"playlist_id" uri:"id" binding:"required,id"`
 }
 
 type DeleteUserPlaylistResp struct {
-	Error string `json:"error"`
-	OK    bool   `json:"ok"`
+	ErrorResp
+	OK bool `json:"ok"`
 }
 
+// GetUserAllPlaylistsReq - authenticated users may list only their own playlists, admins
+// may list playlists of anyone
 type GetUserAllPlaylistsReq struct {
-	UserID string `json:"user_id"`
+	UserID string `json:"user_id" uri:"id" binding:"required,id"`
 }
 
 type GetUserAllPlaylistsResp struct {
-	Error     string                        `json:"error"`
+	ErrorResp
 	Playlists []globalStructs.ShortPlaylist `json:"playlists"`
 }
 
 type GetPlaylistReq struct {
-	PlaylistID string `json:"playlist_id"`
+	PlaylistID string `json:"playlist_id" binding:"required,id"`
 }
 
 type GetPlaylistResp struct {
-	Error    string                 `json:"error"`
+	ErrorResp
 	Playlist globalStructs.Playlist `json:"playlist"`
 }
 
 type AddSongToUserPlaylistReq struct {
-	UserID     string `json:"user_id"`
-	PlaylistID string `json:"playlist_id"`
-	SongID     string `json:"song_id"`
+	UserID     string `json:"user_id" form:"user_id" binding:"omitempty,id"`
+	PlaylistID string `json:"playlist_id" uri:"id" binding:"required,id"`
+	SongID     string `json:"song_id" uri:"songId" binding:"required,id"`
 }
 
 type AddSongToUserPlaylistResp struct {
-	Error string `json:"error"`
-	OK    bool   `json:"ok"`
+	ErrorResp
+	OK bool `json:"ok"`
 }
 
 type RemoveSongFromUserPlaylistReq struct {
-	UserID     string `json:"user_id"`
-	SongID     string `json:"song_id"`
-	PlaylistID string `json:"playlist_id"`
+	UserID     string `json:"user_id" binding:"omitempty,id"`
+	SongID     string `json:"song_id" binding:"required,id"`
+	PlaylistID string `json:"playlist_id" binding:"required,id"`
 }
 
 type RemoveSongFromUserPlaylistResp struct {
-	Error string `json:"error"`
-	OK    bool   `json:"ok"`
+	ErrorResp
+	OK bool `json:"ok"`
+}
+
+// health statuses
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+type HealthResp struct {
+	ErrorResp
+	Status string `json:"status"`
+}
+
+// SetUserRolesReq - roles replace stored roles of the user, user without roles is a listener
+type SetUserRolesReq struct {
+	UserID string   `json:"user_id" binding:"required,id"`
+	Roles  []string `json:"roles" binding:"dive,oneof=listener uploader admin"`
+}
+
+type SetUserRolesResp struct {
+	ErrorResp
+	OK bool `json:"ok"`
+}
+
+// CreateUploadReq - starts resumable upload of song with ts_count ts segments, song data is
+// checked by struct validation registered in internal/validation
+type CreateUploadReq struct {
+	UserID   string             `json:"user_id" binding:"omitempty,id"`
+	SongData globalStructs.Song `json:"song_data"`
+	TsCount  int                `json:"ts_count" binding:"required,min=1,max=2000"`
+}
+
+// UploadSessionReq - session of path, user_id query param is used like user_id of v1 bodies
+type UploadSessionReq struct {
+	ID     string `json:"id" uri:"id" binding:"required,id"`
+	UserID string `json:"user_id" form:"user_id" binding:"omitempty,id"`
+}
+
+// UploadSegmentReq - segment of upload session, N is index of ts and is not used for m3h8.
+// Segment data is the request body
+type UploadSegmentReq struct {
+	ID        string `json:"id" uri:"id" binding:"required,id"`
+	N         int    `json:"n" uri:"n" binding:"min=0"`
+	SegmentID string `json:"segment_id" uri:"segmentId" binding:"required,id"`
+	UserID    string `json:"user_id" form:"user_id" binding:"omitempty,id"`
+}
+
+// UploadSession - progress of resumable upload, Missing are indexes of ts which are not uploaded yet.
+// Session which gets no segment until ExpiresAt is removed with its segments
+type UploadSession struct {
+	ID        string             `json:"id"`
+	SongData  globalStructs.Song `json:"song_data"`
+	TsCount   int                `json:"ts_count"`
+	M3H8      string             `json:"m3h8"`
+	Received  int                `json:"received"`
+	Missing   []int              `json:"missing"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+type UploadSessionResp struct {
+	ErrorResp
+	Session UploadSession `json:"session"`
+}
+
+// CommitUploadResp - FailedPart is set like in AddSegmentsResp when session is not complete
+type CommitUploadResp struct {
+	ErrorResp
+	OK         bool   `json:"ok"`
+	FailedPart string `json:"failed_part"`
+}
+
+type AbortUploadResp struct {
+	ErrorResp
+	OK bool `json:"ok"`
 }